@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+)
+
+func GetCSRFToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Reuse the browser's CSRF token or set a new one in the CSRF cookie.
+		token, err := helpers.IssueCSRFToken(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while creating the CSRF token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"csrf_token": token, "header": helpers.CSRFHeader})
+	}
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+)
+
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Let verifiers cache the key set for a few minutes instead of fetching it on every request.
+		c.Header("Cache-Control", "public, max-age=300")
+
+		// Return the public verification keys as a JWK Set.
+		c.JSON(http.StatusOK, helpers.JWKS())
+	}
+}
+
+func GetSigningKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Check if the current user has ADMIN role. Return an error if not.
+		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// List the key ring; private key material is never serialized.
+		keys, err := helpers.ListSigningKeys()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing signing keys"})
+			return
+		}
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+func AddSigningKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Check if the current user has ADMIN role. Return an error if not.
+		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The request body names the algorithm of the key to generate.
+		var body struct {
+			Algorithm *string `json:"algorithm" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Generate the key; it starts out verify-only until it is promoted.
+		key, err := helpers.AddSigningKey(*body.Algorithm)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, key)
+	}
+}
+
+func PromoteSigningKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Check if the current user has ADMIN role. Return an error if not.
+		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Make the key active; the previous active key keeps verifying during the grace period.
+		if err := helpers.PromoteSigningKey(c.Param("kid")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": "signing key promoted"})
+	}
+}
+
+func RetireSigningKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Check if the current user has ADMIN role. Return an error if not.
+		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Stop trusting the key; tokens it signed are rejected from now on.
+		if err := helpers.RetireSigningKey(c.Param("kid")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": "signing key retired"})
+	}
+}
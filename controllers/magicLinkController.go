@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func RequestMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The request body names the account's email address.
+		var body struct {
+			Email *string `json:"email" validate:"email,required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Bind the link to this browser. The cookie is set whether or not the account exists,
+		// so the response doesn't reveal which addresses have accounts. A browser asking again keeps
+		// its nonce, so the link it already has still works when the email cooldown drops the request.
+		nonce, _ := c.Cookie(helpers.MagicLinkNonceCookie)
+		if nonce == "" {
+			var err error
+			nonce, err = helpers.NewOpaqueToken()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while creating the sign-in link"})
+				return
+			}
+		}
+		helpers.SetMagicLinkNonceCookie(c, nonce)
+
+		// Send a sign-in link if the account exists. This happens in the background and failures
+		// are only logged, so the response time doesn't reveal whether the account exists either.
+		go func(email string) {
+			// Create a context with a 100-second timeout for the database operation.
+			var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+			defer cancel()
+
+			var foundUser models.User
+			if err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&foundUser); err != nil {
+				return
+			}
+			if err := helpers.SendMagicLink(foundUser.User_id, *foundUser.Email, nonce); err != nil {
+				log.Println("error sending magic link email:", err)
+			}
+		}(*body.Email)
+
+		c.JSON(http.StatusOK, gin.H{"success": "if an account exists for this email, a sign-in link has been sent"})
+	}
+}
+
+func MagicLinkCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		// Redeem the single-use link with the nonce cookie of the browser that requested it.
+		nonce, _ := c.Cookie(helpers.MagicLinkNonceCookie)
+		link, err := helpers.ConsumeMagicLink(token, nonce)
+		helpers.ClearMagicLinkNonceCookie(c)
+		if err == helpers.ErrInvalidMagicLink {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the sign-in link"})
+			return
+		}
+
+		// The link only works for the address it was sent to.
+		var foundUser models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": link.User_id, "email": link.Email}).Decode(&foundUser); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": helpers.ErrInvalidMagicLink.Error()})
+			return
+		}
+
+		// Opening the link proves control of the mailbox, which is what email verification checks.
+		if !foundUser.Email_verified {
+			updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			_, err := userCollection.UpdateOne(
+				ctx,
+				bson.M{"user_id": foundUser.User_id, "email": link.Email},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "email_verified", Value: true},
+					{Key: "updated_at", Value: updatedAt},
+				}}},
+			)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email address"})
+				return
+			}
+			foundUser.Email_verified = true
+		}
+
+		// The link stands in for the password: ask for the second factor or issue the token pair
+		completeLogin(c, foundUser)
+	}
+}
@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mfaCodeRequest is the second factor sent to the MFA endpoints: either a current TOTP code
+// or one of the user's unused recovery codes.
+type mfaCodeRequest struct {
+	Code          string `json:"code"`
+	Recovery_code string `json:"recovery_code"`
+}
+
+// verifySecondFactor checks a TOTP or recovery code for a user with MFA enabled. Both are
+// single-use: the TOTP time step is recorded and a recovery code is removed in the same update
+// that accepts it, so concurrent requests can't use the same code twice.
+func verifySecondFactor(ctx context.Context, user models.User, request mfaCodeRequest) (bool, error) {
+	if !user.Mfa_enabled || user.Totp_secret == nil {
+		return false, nil
+	}
+
+	if request.Code != "" {
+		secret, err := helpers.OpenTOTPSecret(user.User_id, *user.Totp_secret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := helpers.ValidateTOTPCode(secret, request.Code, user.Totp_last_step)
+		if !ok {
+			return false, nil
+		}
+		result, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id, "totp_last_step": bson.M{"$lt": step}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "totp_last_step", Value: step}}}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount == 1, nil
+	}
+
+	if request.Recovery_code != "" {
+		hash := helpers.HashRecoveryCode(request.Recovery_code)
+		result, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id, "recovery_codes": hash},
+			bson.D{{Key: "$pull", Value: bson.D{{Key: "recovery_codes", Value: hash}}}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount == 1, nil
+	}
+
+	return false, nil
+}
+
+// findCurrentUser loads the authenticated caller's user document.
+func findCurrentUser(ctx context.Context, c *gin.Context) (models.User, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user)
+	return user, err
+}
+
+func EnrollTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findCurrentUser(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+			return
+		}
+		if user.Mfa_enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		// Generate a secret; it only takes effect once confirmed with a first code.
+		// It is stored sealed and only ever shown here.
+		enrollment, err := helpers.GenerateTOTPEnrollment(*user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while generating the TOTP secret"})
+			return
+		}
+		sealed, err := helpers.SealTOTPSecret(user.User_id, enrollment.Secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while storing the TOTP secret"})
+			return
+		}
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "totp_pending_secret", Value: sealed}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while storing the TOTP secret"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      enrollment.Secret,
+			"otpauth_uri": enrollment.URI,
+			"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrollment.QRCode),
+		})
+	}
+}
+
+func ConfirmTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// The request body carries the first code from the authenticator app.
+		var body struct {
+			Code *string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		user, err := findCurrentUser(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+			return
+		}
+		if user.Totp_pending_secret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no TOTP enrollment in progress"})
+			return
+		}
+
+		// The code proves the authenticator app holds the pending secret.
+		secret, err := helpers.OpenTOTPSecret(user.User_id, *user.Totp_pending_secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the TOTP secret"})
+			return
+		}
+		step, ok := helpers.ValidateTOTPCode(secret, *body.Code, 0)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		// Enable MFA with a fresh set of recovery codes.
+		codes, hashes, err := helpers.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while generating recovery codes"})
+			return
+		}
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "mfa_enabled", Value: true},
+					{Key: "totp_secret", Value: *user.Totp_pending_secret}, // Still sealed for the same user
+					{Key: "totp_last_step", Value: step},
+					{Key: "recovery_codes", Value: hashes},
+					{Key: "updated_at", Value: updatedAt},
+				}},
+				{Key: "$unset", Value: bson.D{{Key: "totp_pending_secret", Value: ""}}},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while enabling two-factor authentication"})
+			return
+		}
+
+		helpers.LogSecurityEvent("mfa_enabled", map[string]interface{}{"user_id": user.User_id})
+
+		// The recovery codes are only ever shown here.
+		c.JSON(http.StatusOK, gin.H{"success": "two-factor authentication enabled", "recovery_codes": codes})
+	}
+}
+
+func DisableTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// Disabling requires both the password and a second factor.
+		var body struct {
+			Password *string `json:"password" validate:"required"`
+			mfaCodeRequest
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		user, err := findCurrentUser(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+			return
+		}
+		if passwordIsValid, _ := VerifyPassword(*body.Password, *user.Password); !passwordIsValid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "password is incorrect"})
+			return
+		}
+		valid, err := verifySecondFactor(ctx, user, body.mfaCodeRequest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the code"})
+			return
+		}
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id},
+			bson.D{
+				{Key: "$set", Value: bson.D{{Key: "mfa_enabled", Value: false}, {Key: "updated_at", Value: updatedAt}}},
+				{Key: "$unset", Value: bson.D{
+					{Key: "totp_secret", Value: ""},
+					{Key: "totp_pending_secret", Value: ""},
+					{Key: "totp_last_step", Value: ""},
+					{Key: "recovery_codes", Value: ""},
+				}},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while disabling two-factor authentication"})
+			return
+		}
+
+		helpers.LogSecurityEvent("mfa_disabled", map[string]interface{}{"user_id": user.User_id})
+		c.JSON(http.StatusOK, gin.H{"success": "two-factor authentication disabled"})
+	}
+}
+
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// Regenerating requires a current TOTP code.
+		var body struct {
+			Code *string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		user, err := findCurrentUser(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+			return
+		}
+		valid, err := verifySecondFactor(ctx, user, mfaCodeRequest{Code: *body.Code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the code"})
+			return
+		}
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		// Replace every previous recovery code.
+		codes, hashes, err := helpers.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while generating recovery codes"})
+			return
+		}
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "recovery_codes", Value: hashes}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while storing recovery codes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+func VerifyMFALogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// The request body carries the MFA pending token from the password step and the second factor.
+		var body struct {
+			Mfa_token *string `json:"mfa_token" validate:"required"`
+			mfaCodeRequest
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		claims, foundUser, ok := beginMFAAttempt(ctx, c, *body.Mfa_token)
+		if !ok {
+			return
+		}
+
+		valid, err := verifySecondFactor(ctx, foundUser, body.mfaCodeRequest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the code"})
+			return
+		}
+		if !valid {
+			failMFAAttempt(c, claims, foundUser, "invalid code")
+			return
+		}
+
+		finishMFAAttempt(c, claims, foundUser)
+	}
+}
+
+// beginMFAAttempt starts the second step of a login: it checks the MFA pending token is valid and
+// neither used nor burned, loads its user and counts the attempt like a password attempt, per account
+// and client IP. If the attempt can't go on it responds and returns false.
+func beginMFAAttempt(ctx context.Context, c *gin.Context, signedToken string) (*helpers.SignedDetails, models.User, bool) {
+	var foundUser models.User
+
+	claims, msg := helpers.ValidateMFAPendingToken(signedToken)
+	if msg != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+		return nil, foundUser, false
+	}
+
+	// A pending token that was already used or burned by too many wrong codes is dead.
+	revoked, err := helpers.IsTokenRevoked(claims.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the MFA token"})
+		return nil, foundUser, false
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid MFA token"})
+		return nil, foundUser, false
+	}
+
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&foundUser); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid MFA token"})
+		return nil, foundUser, false
+	}
+
+	// Second factors are throttled like passwords, per account and client IP.
+	wait, err := helpers.BeginLoginAttempt(*foundUser.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking login attempts"})
+		return nil, foundUser, false
+	}
+	if wait > 0 {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later", "retry_after": retryAfter})
+		return nil, foundUser, false
+	}
+	return claims, foundUser, true
+}
+
+// failMFAAttempt records a wrong second factor against the account, the client IP and the pending
+// token, and responds with 401. Once the token is burned the user has to log in again.
+func failMFAAttempt(c *gin.Context, claims *helpers.SignedDetails, foundUser models.User, message string) {
+	recordLoginFailure(*foundUser.Email, c.ClientIP())
+	burned, err := helpers.RecordMFAFailure(claims)
+	if err != nil {
+		log.Println("error recording failed MFA attempt:", err)
+	}
+	if burned {
+		message = "too many invalid attempts, log in again"
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// finishMFAAttempt completes a login whose second factor was right: the attempt doesn't count
+// against the client IP, the single-use pending token is consumed and the token pair is issued.
+func finishMFAAttempt(c *gin.Context, claims *helpers.SignedDetails, foundUser models.User) {
+	if err := helpers.ReleaseLoginAttempt(c.ClientIP()); err != nil {
+		log.Println("error releasing login attempt:", err)
+	}
+
+	consumed, err := helpers.ConsumeToken(claims.Id, claims.Uid, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while completing the login"})
+		return
+	}
+	if !consumed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid MFA token"})
+		return
+	}
+
+	issueLoginTokens(c, foundUser)
+}
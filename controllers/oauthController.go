@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+)
+
+// requireOAuthClient authenticates the calling OAuth client and returns its client ID, answering
+// with the RFC 6749 invalid_client error if the credentials are missing or wrong.
+func requireOAuthClient(c *gin.Context) (string, bool) {
+	clientID, ok := helpers.AuthenticateClient(c)
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return "", false
+	}
+	return clientID, true
+}
+
+// isTokenActive checks the revocation state of a token that already passed ValidateToken.
+func isTokenActive(claims *helpers.SignedDetails) (bool, error) {
+	// A refresh token is only active while it is the current member of a live session.
+	if claims.Token_type == helpers.TokenTypeRefresh {
+		return helpers.IsRefreshTokenCurrent(claims.Session_id, claims.Id)
+	}
+
+	// An access token is active unless it or its session was revoked.
+	revoked, err := helpers.IsAccessTokenRevoked(claims)
+	return !revoked, err
+}
+
+func Introspect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Introspection responses must not be cached.
+		c.Header("Cache-Control", "no-store")
+
+		// Only registered OAuth clients may introspect tokens.
+		clientID, ok := requireOAuthClient(c)
+		if !ok {
+			return
+		}
+
+		// The token is sent as a form field.
+		signedToken := c.PostForm("token")
+		if signedToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+			return
+		}
+
+		// Invalid, expired or revoked tokens are reported as inactive without further detail.
+		// Only access and refresh tokens are introspectable; short-lived tokens of the login and
+		// verification flows are internal and reported as inactive too.
+		claims, msg := helpers.ValidateToken(signedToken)
+		if msg != "" || (claims.Token_type != helpers.TokenTypeAccess && claims.Token_type != helpers.TokenTypeRefresh) {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+		active, err := isTokenActive(claims)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		if !active {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+
+		// Describe the active token in the RFC 7662 response shape. The tokens carry no authorized
+		// party of their own, so client_id is the resource server's client that asked about it.
+		response := gin.H{
+			"active":     true,
+			"client_id":  clientID,
+			"sub":        claims.Subject,
+			"iss":        claims.Issuer,
+			"aud":        claims.Audience,
+			"exp":        claims.ExpiresAt,
+			"iat":        claims.IssuedAt,
+			"nbf":        claims.NotBefore,
+			"jti":        claims.Id,
+			"token_type": claims.Token_type,
+		}
+		// Only access tokens carry the user's identity; their scope follows the user type.
+		if claims.Token_type == helpers.TokenTypeAccess {
+			response["scope"] = strings.ToLower(claims.User_type)
+			response["username"] = claims.Email
+			response["user_type"] = claims.User_type
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func Revoke() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Only registered OAuth clients may revoke tokens.
+		if _, ok := requireOAuthClient(c); !ok {
+			return
+		}
+
+		// The token is sent as a form field, optionally with a hint about its type.
+		signedToken := c.PostForm("token")
+		if signedToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+			return
+		}
+		switch c.PostForm("token_type_hint") {
+		case "", "access_token", "refresh_token":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_token_type"})
+			return
+		}
+
+		// Invalid or expired tokens need no revocation; RFC 7009 answers 200 either way.
+		// The token's own type claim decides how it is revoked, the hint is only advisory.
+		claims, msg := helpers.ValidateToken(signedToken)
+		if msg != "" {
+			c.Status(http.StatusOK)
+			return
+		}
+
+		var err error
+		if claims.Token_type == helpers.TokenTypeRefresh {
+			// Ending the session also denylists the access token derived from the refresh token.
+			err = helpers.RevokeSession(claims.Session_id)
+		} else {
+			err = helpers.RevokeToken(claims.Id, claims.Subject, time.Unix(claims.ExpiresAt, 0))
+		}
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server_error"})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
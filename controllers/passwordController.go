@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// checkPasswordPolicy checks a new password against the password policy for the user. If it breaks
+// any rule it responds with 400 and the violated rules, and returns false.
+func checkPasswordPolicy(c *gin.Context, password string, user models.User, previousHashes []string) bool {
+	violations, err := helpers.CheckPasswordPolicy(helpers.PasswordCandidate{
+		Password:        password,
+		Email:           models.StringValue(user.Email),
+		First_name:      models.StringValue(user.First_name),
+		Last_name:       models.StringValue(user.Last_name),
+		Previous_hashes: previousHashes,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the password"})
+		return false
+	}
+	if len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password does not meet the password policy", "violations": violations})
+		return false
+	}
+	return true
+}
+
+// passwordHashes returns the user's current password hash followed by their password history.
+func passwordHashes(user models.User) []string {
+	hashes := []string{}
+	if user.Password != nil {
+		hashes = append(hashes, *user.Password)
+	}
+	return append(hashes, user.Password_history...)
+}
+
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The request body names the account's email address.
+		var body struct {
+			Email *string `json:"email" validate:"email,required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Send a reset link if the account exists. This happens in the background and failures
+		// are only logged: the response is the same, and takes as long, either way, so it doesn't
+		// reveal which addresses have accounts.
+		go func(email string) {
+			// Create a context with a 100-second timeout for the database operation.
+			var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+			defer cancel()
+
+			var foundUser models.User
+			if err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&foundUser); err != nil {
+				return
+			}
+			if err := helpers.SendPasswordResetEmail(foundUser.User_id, *foundUser.Email); err != nil {
+				log.Println("error sending password reset email:", err)
+			}
+		}(*body.Email)
+
+		c.JSON(http.StatusOK, gin.H{"success": "if an account exists for this email, a password reset link has been sent"})
+	}
+}
+
+func CheckPasswordReset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The reset link carries its token as a query parameter.
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		// Only look the token up; it is redeemed when the new password is POSTed.
+		_, err := helpers.FindPasswordReset(token)
+		if err == helpers.ErrInvalidResetToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the reset token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "reset token is valid, POST it with the new password to reset the password"})
+	}
+}
+
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// The request body carries the emailed reset token and the new password.
+		var body struct {
+			Token    *string `json:"token" validate:"required"`
+			Password *string `json:"password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Validate before redeeming, so a rejected password doesn't burn the token.
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Look up the account the token was issued to, and check the new password against the
+		// policy before redeeming, so a rejected password doesn't burn the token either.
+		userId, err := helpers.FindPasswordReset(*body.Token)
+		if err == helpers.ErrInvalidResetToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resetting the password"})
+			return
+		}
+		var foundUser models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&foundUser); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+			return
+		}
+		if !checkPasswordPolicy(c, *body.Password, foundUser, passwordHashes(foundUser)) {
+			return
+		}
+
+		// Redeem the single-use token.
+		redeemedUserId, err := helpers.ConsumePasswordReset(*body.Token)
+		if err == helpers.ErrInvalidResetToken || (err == nil && redeemedUserId != userId) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helpers.ErrInvalidResetToken.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resetting the password"})
+			return
+		}
+
+		// Store the new password hash, keeping the old one in the password history.
+		password := HashPassword(*body.Password)
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": userId},
+			helpers.PasswordHistoryUpdate(password, *foundUser.Password, bson.D{{Key: "updated_at", Value: updatedAt}}),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resetting the password"})
+			return
+		}
+
+		// Whoever knew the old password must not stay logged in, nor keep a passkey they registered.
+		if err := helpers.RevokeAllSessions(userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while revoking sessions"})
+			return
+		}
+		if err := helpers.DeleteAllWebAuthnCredentials(userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while removing passkeys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "password has been reset"})
+	}
+}
+
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// The request body carries the current password and the new one.
+		var body struct {
+			Current_password *string `json:"current_password" validate:"required"`
+			New_password     *string `json:"new_password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Load the caller's account.
+		userId := c.GetString("uid")
+		var foundUser models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&foundUser); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+			return
+		}
+
+		// The current password must be proven before it can be replaced.
+		if passwordIsValid, _ := VerifyPassword(*body.Current_password, *foundUser.Password); !passwordIsValid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current password is incorrect"})
+			return
+		}
+		if *body.New_password == *body.Current_password {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new password must be different from the current password"})
+			return
+		}
+		if !checkPasswordPolicy(c, *body.New_password, foundUser, passwordHashes(foundUser)) {
+			return
+		}
+
+		// Store the new password hash, keeping the old one in the password history.
+		password := HashPassword(*body.New_password)
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": userId},
+			helpers.PasswordHistoryUpdate(password, *foundUser.Password, bson.D{{Key: "updated_at", Value: updatedAt}}),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while changing the password"})
+			return
+		}
+
+		// Sign out every other device, but keep the session the change was made from.
+		if err := helpers.RevokeOtherSessions(userId, c.GetString("session_id")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while revoking sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "password has been changed"})
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+)
+
+func GetSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// List the caller's own live sessions.
+		sessions, err := helpers.ListSessions(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing sessions"})
+			return
+		}
+
+		// Flag the session the request was made from.
+		currentSessionID := c.GetString("session_id")
+		items := make([]gin.H, 0, len(sessions))
+		for _, session := range sessions {
+			items = append(items, gin.H{
+				"session_id":   session.Session_id,
+				"device_name":  session.Device_name,
+				"user_agent":   session.User_agent,
+				"ip_address":   session.Ip_address,
+				"created_at":   session.Created_at,
+				"last_seen_at": session.Last_seen_at,
+				"current":      session.Session_id == currentSessionID,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sessions": items})
+	}
+}
+
+func DeleteSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Users can only terminate their own sessions.
+		err := helpers.RevokeUserSession(c.GetString("uid"), c.Param("id"))
+		if err == helpers.ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while ending the session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "session terminated"})
+	}
+}
@@ -3,7 +3,9 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -16,32 +18,51 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 var validate = validator.New()
 
+// HashPassword hashes a password with the configured PasswordHasher (argon2id by default).
 func HashPassword(password string) string {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	hashedPassword, err := helpers.HashPassword(password)
 	if err != nil {
 		log.Panic(err)
 	}
-	return string(hashedPassword)
+	return hashedPassword
 }
 
+// VerifyPassword checks a password against a stored argon2id or bcrypt hash.
 func VerifyPassword(userPassword, providedPassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(userPassword))
-	check := true
+	check, err := helpers.VerifyPassword(userPassword, providedPassword)
 	msg := ""
 
-	if err != nil {
+	if err != nil || !check {
 		msg = fmt.Sprintf("email or password is incorrect")
 		check = false
 	}
 	return check, msg
 }
 
+// rehashPassword replaces a stored hash made with an outdated algorithm or parameters, now that
+// the plaintext password is known to be right. It only replaces the hash it was read with, so a
+// concurrent password change wins, and a failure is only logged since the old hash still works.
+func rehashPassword(ctx context.Context, userID, password, oldHash string) {
+	newHash, err := helpers.HashPassword(password)
+	if err != nil {
+		log.Println("error rehashing password:", err)
+		return
+	}
+	_, err = userCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID, "password": oldHash},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: newHash}}}},
+	)
+	if err != nil {
+		log.Println("error storing rehashed password:", err)
+	}
+}
+
 func Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Create a context with a timeout of 100 seconds.
@@ -77,8 +98,13 @@ func Signup() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "this email already exists"})
 			return
 		}
+		// Check the password against the password policy before it is hashed.
+		if !checkPasswordPolicy(c, *user.Password, user, nil) {
+			return
+		}
 		password := HashPassword(*user.Password)
 		user.Password = &password
+		user.Password_history = []string{}
 		// Check if an account with the same phone number already exists.
 		count, err = userCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
 		if err != nil {
@@ -100,10 +126,9 @@ func Signup() gin.HandlerFunc {
 		user.ID = primitive.NewObjectID()
 		user.User_id = user.ID.Hex()
 
-		// Generate authentication tokens for the user.
-		token, refreshToken, _ := helpers.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id)
-		user.Token = &token
-		user.Refresh_token = &refreshToken
+		// New accounts start unverified and without MFA, whatever the request body says.
+		user.Email_verified = false
+		user.Mfa_enabled = false
 
 		// Insert the new user document into the userCollection.
 		resultInsertionNumber, insertErr := userCollection.InsertOne(ctx, user)
@@ -113,9 +138,55 @@ func Signup() gin.HandlerFunc {
 			return
 		}
 
-		// Return the result of the insertion.
-		c.JSON(http.StatusOK, resultInsertionNumber)
+		// Send the email verification link unless verification is turned off.
+		policy := helpers.EmailVerificationPolicy()
+		if policy != helpers.EmailVerificationOff {
+			if err := helpers.SendVerificationEmail(user.User_id, *user.Email); err != nil {
+				log.Println("error sending verification email:", err)
+			}
+		}
+
+		// When verification is required, no tokens are issued until the email is verified.
+		if policy == helpers.EmailVerificationRequire {
+			c.JSON(http.StatusOK, gin.H{
+				"InsertedID": resultInsertionNumber.InsertedID,
+				"message":    "a verification link has been sent to your email address",
+			})
+			return
+		}
+
+		// Generate authentication tokens for the user in a new login session.
+		sessionID := helpers.NewSessionID()
+		token, refreshToken, _ := helpers.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, sessionID, user.Email_verified, false)
+
+		// Track the refresh token family for the new session.
+		if err := helpers.StartSession(c, sessionID, user.User_id, token, refreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while creating the session"})
+			return
+		}
+
+		// Return the result of the insertion along with the freshly minted tokens,
+		// which are set as cookies instead in cookie mode.
+		if helpers.AuthCookiesEnabled() {
+			helpers.SetAuthCookies(c, token, refreshToken)
+			c.JSON(http.StatusOK, gin.H{"InsertedID": resultInsertionNumber.InsertedID})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"InsertedID":    resultInsertionNumber.InsertedID,
+			"token":         token,
+			"refresh_token": refreshToken,
+		})
+	}
+}
+
+// tokenPairBody returns the token pair as it is included in a response body: empty in cookie mode,
+// where SetAuthCookies delivers it instead.
+func tokenPairBody(token, refreshToken string) (string, string) {
+	if helpers.AuthCookiesEnabled() {
+		return "", ""
 	}
+	return token, refreshToken
 }
 
 func Login() gin.HandlerFunc {
@@ -131,10 +202,30 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		// Count the attempt, and refuse it without checking the password while the account
+		// or client IP is backing off or locked out after earlier failures
+		wait, err := helpers.BeginLoginAttempt(*user.Email, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking login attempts"})
+			return
+		}
+		if wait > 0 {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later", "retry_after": retryAfter})
+			return
+		}
+
 		// Find the user in the database by email
-		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode((&foundUser))
+		err = userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode((&foundUser))
 		defer cancel() // Again, cancel context if needed
 		if err != nil {
+			recordLoginFailure(*user.Email, c.ClientIP())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"}) // Return error if user not found
 			return
 		}
@@ -143,31 +234,112 @@ func Login() gin.HandlerFunc {
 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
 		defer cancel() // Cancel context if needed
 		if !passwordIsValid {
+			recordLoginFailure(*user.Email, c.ClientIP())
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg}) // Return error if password is invalid
 			return
 		}
 
+		// The password was right: the attempt doesn't count against the client IP. The account's
+		// earlier failures are only forgotten once any second factor has been checked too
+		if err := helpers.ReleaseLoginAttempt(c.ClientIP()); err != nil {
+			log.Println("error releasing login attempt:", err)
+		}
+
+		// Upgrade the stored hash if it was made with an outdated algorithm or parameters
+		if helpers.PasswordNeedsRehash(*foundUser.Password) {
+			rehashPassword(ctx, foundUser.User_id, *user.Password, *foundUser.Password)
+		}
+
 		// Check if the user was found
 		if *foundUser.Email == "" {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"}) // Return error if no email
 		}
 
-		// Generate JWT and refresh token for the found user
-		token, refreshToken, _ := helpers.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id)
-		// Update the user's tokens in the database
-		helpers.UpdateAllTokens(token, refreshToken, foundUser.User_id)
+		// Ask for the second factor or issue the token pair
+		completeLogin(c, foundUser)
+	}
+}
+
+// recordLoginFailure marks a counted login attempt as failed; a failure to record it is only logged.
+func recordLoginFailure(email, ip string) {
+	if err := helpers.RecordLoginFailure(email, ip); err != nil {
+		log.Println("error recording failed login attempt:", err)
+	}
+}
 
-		// Retrieve updated user information from the database
-		err = userCollection.FindOne(ctx, bson.M{"user_id": foundUser.User_id}).Decode(&foundUser)
+// completeLogin finishes a login whose first factor has been checked. Unverified accounts are
+// refused when the policy requires it; when MFA is enabled or the user has registered a passkey, a
+// short-lived MFA pending token is returned instead of the token pair, to be exchanged together with
+// a valid second factor. The response lists the second factors the user can choose from.
+func completeLogin(c *gin.Context, foundUser models.User) {
+	// Refuse unverified accounts when the policy requires a verified email
+	if refuseUnverifiedLogin(c, foundUser) {
+		return
+	}
 
-		// Return error if fetching updated user info fails
+	// Two-step login: the password alone only earns an MFA pending token
+	hasPasskey, err := helpers.HasWebAuthnCredentials(foundUser.User_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while starting the login"})
+		return
+	}
+	if foundUser.Mfa_enabled || hasPasskey {
+		mfaToken, err := helpers.GenerateMFAPendingToken(foundUser.User_id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while starting the MFA login"})
 			return
 		}
-		// Return the found user information as JSON
-		c.JSON(http.StatusOK, foundUser)
+		methods := []string{}
+		if foundUser.Mfa_enabled {
+			methods = append(methods, "totp", "recovery_code")
+		}
+		if hasPasskey {
+			methods = append(methods, "webauthn")
+		}
+		c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken, "mfa_methods": methods})
+		return
+	}
+
+	issueLoginTokens(c, foundUser)
+}
+
+// refuseUnverifiedLogin responds with 403 and returns true when the email verification policy
+// requires a verified address the user doesn't have yet.
+func refuseUnverifiedLogin(c *gin.Context, foundUser models.User) bool {
+	if !foundUser.Email_verified && helpers.EmailVerificationPolicy() == helpers.EmailVerificationRequire {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email address is not verified"})
+		return true
+	}
+	return false
+}
+
+// issueLoginTokens starts a new login session for a fully authenticated user and returns the
+// user along with the freshly minted token pair.
+func issueLoginTokens(c *gin.Context, foundUser models.User) {
+	// The login succeeded: forget the account's earlier failures
+	if err := helpers.ResetLoginFailures(*foundUser.Email); err != nil {
+		log.Println("error resetting failed login attempts:", err)
+	}
+
+	// Generate JWT and refresh token for the found user in a new login session.
+	// A user with a second factor has just used it: completeLogin sent them through the MFA step.
+	mfaEnabled, err := helpers.HasSecondFactor(foundUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while creating the session"})
+		return
+	}
+	sessionID := helpers.NewSessionID()
+	token, refreshToken, _ := helpers.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id, sessionID, foundUser.Email_verified, mfaEnabled)
+	// Track the refresh token family for this login
+	if err := helpers.StartSession(c, sessionID, foundUser.User_id, token, refreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while creating the session"})
+		return
 	}
+	// Return the found user information along with the freshly minted tokens as JSON,
+	// or set them as cookies in cookie mode
+	helpers.SetAuthCookies(c, token, refreshToken)
+	bodyToken, bodyRefreshToken := tokenPairBody(token, refreshToken)
+	c.JSON(http.StatusOK, models.LoginResponse{UserResponse: models.NewUserResponse(foundUser), Token: bodyToken, Refresh_token: bodyRefreshToken})
 }
 
 func GetUsers() gin.HandlerFunc {
@@ -230,13 +402,27 @@ func GetUsers() gin.HandlerFunc {
 		}
 
 		// Retrieve all documents from the result.
-		var allUsers []bson.M
+		var allUsers []struct {
+			Total_count int           `bson:"total_count"`
+			User_items  []models.User `bson:"user_items"`
+		}
 		if err = result.All(ctx, &allUsers); err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing items"})
+			return
+		}
+
+		// Convert the page of users to the admin response type; an empty collection yields no group.
+		totalCount := 0
+		userItems := []models.AdminUserResponse{}
+		if len(allUsers) > 0 {
+			totalCount = allUsers[0].Total_count
+			for _, user := range allUsers[0].User_items {
+				userItems = append(userItems, models.NewAdminUserResponse(user))
+			}
 		}
 
 		// Return the result as JSON.
-		c.JSON(http.StatusOK, allUsers[0])
+		c.JSON(http.StatusOK, gin.H{"total_count": totalCount, "user_items": userItems})
 	}
 }
 
@@ -266,7 +452,181 @@ func GetUser() gin.HandlerFunc {
 			return
 		}
 
-		// Return the user data as a JSON response.
-		c.JSON(http.StatusOK, user)
+		// Return the view of the user data the caller may see as a JSON response.
+		c.JSON(http.StatusOK, models.UserResponseFor(user, c.GetString("user_type")))
+	}
+}
+
+func Refresh() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel() // Ensure the context is canceled at the end
+
+		// The request body carries the refresh token issued at login. In cookie mode it may
+		// be left out, and the refresh token cookie is used instead.
+		var body struct {
+			Refresh_token *string `json:"refresh_token" validate:"required"`
+		}
+
+		// Bind incoming JSON request to the body struct
+		if err := c.ShouldBindJSON(&body); err != nil && !(err == io.EOF && helpers.AuthCookiesEnabled()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Refresh_token == nil && helpers.AuthCookiesEnabled() {
+			if cookie, err := c.Cookie(helpers.RefreshTokenCookie); err == nil && cookie != "" {
+				body.Refresh_token = &cookie
+			}
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Make sure the refresh token is correctly signed, not expired and really a refresh token.
+		claims, msg := helpers.ValidateRefreshToken(*body.Refresh_token)
+		if msg != "" {
+			helpers.ClearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		// Tokens minted before sessions were tracked cannot be rotated.
+		if claims.Session_id == "" || claims.Id == "" {
+			helpers.ClearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": helpers.ErrInvalidRefreshToken.Error()})
+			return
+		}
+
+		// Find the user the refresh token was issued to.
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&foundUser)
+		if err != nil {
+			helpers.ClearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": helpers.ErrInvalidRefreshToken.Error()})
+			return
+		}
+
+		// Generate a fresh token pair in the same session and rotate the family to it.
+		// A replayed, already-rotated token revokes the whole family instead.
+		// A session only keeps admin rights if it was started with a second factor the user still has.
+		mfaEnabled, err := helpers.HasSecondFactor(foundUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while refreshing the tokens"})
+			return
+		}
+		mfaEnabled = mfaEnabled && claims.Mfa_enabled
+		token, refreshToken, _ := helpers.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id, claims.Session_id, foundUser.Email_verified, mfaEnabled)
+		if err := helpers.RotateSession(claims.Session_id, *body.Refresh_token, token, refreshToken); err != nil {
+			helpers.ClearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Return the new token pair as JSON, or replace the cookies in cookie mode
+		if helpers.AuthCookiesEnabled() {
+			helpers.SetAuthCookies(c, token, refreshToken)
+			c.JSON(http.StatusOK, gin.H{"success": "tokens refreshed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Denylist the access token used for this request until it would have expired.
+		expiresAt := time.Unix(c.GetInt64("expires_at"), 0)
+		if err := helpers.RevokeToken(c.GetString("jti"), c.GetString("uid"), expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while revoking the token"})
+			return
+		}
+
+		// End the login session so its refresh token can't mint new access tokens.
+		if sessionID := c.GetString("session_id"); sessionID != "" {
+			if err := helpers.RevokeSession(sessionID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while ending the session"})
+				return
+			}
+		}
+
+		// Remove the token cookies in cookie mode.
+		helpers.ClearAuthCookies(c)
+		c.JSON(http.StatusOK, gin.H{"success": "logged out"})
+	}
+}
+
+func RevokeUserSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Check if the current user has ADMIN role. Return an error if not.
+		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Revoke every session of the user, along with the access tokens issued from them.
+		userId := c.Param("user_id")
+		if err := helpers.RevokeAllSessions(userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while revoking sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "all sessions revoked"})
+	}
+}
+
+func VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The verification link carries its token as a query parameter.
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		// Consume the link and mark the email address as verified.
+		err := helpers.VerifyEmail(token)
+		if err == helpers.ErrInvalidVerificationToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "email address verified"})
+	}
+}
+
+func ResendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// The request body names the email address to verify.
+		var body struct {
+			Email *string `json:"email" validate:"email,required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// Only unverified accounts get a new link. The response is the same either way,
+		// so it doesn't reveal which addresses have accounts.
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": *body.Email, "email_verified": false}).Decode(&foundUser)
+		if err == nil && helpers.EmailVerificationPolicy() != helpers.EmailVerificationOff {
+			if err := helpers.SendVerificationEmail(foundUser.User_id, *foundUser.Email); err != nil {
+				log.Println("error sending verification email:", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "if the account exists and is unverified, a new verification link has been sent"})
 	}
 }
@@ -0,0 +1,131 @@
+//go:build integration
+
+// The handlers that return users, run against MongoDB. Run with
+// `go test -tags integration ./controllers/` and the database configured in .env.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mintedTokenKeys are the keys Signup and Login return the freshly minted token pair under.
+// They are only allowed at the top level of those two responses.
+var mintedTokenKeys = map[string]bool{"token": true, "refresh_token": true}
+
+// leakedKeys returns the keys of a decoded JSON body that must never be sent: a password, or
+// a stored token, at any depth. allowMinted permits the token pair at the top level.
+func leakedKeys(value interface{}, topLevel, allowMinted bool) []string {
+	var leaked []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			lower := strings.ToLower(key)
+			minted := topLevel && allowMinted && mintedTokenKeys[lower]
+			if strings.Contains(lower, "password") || ((lower == "token" || strings.HasSuffix(lower, "_token")) && !minted) {
+				leaked = append(leaked, key)
+			}
+			leaked = append(leaked, leakedKeys(nested, false, allowMinted)...)
+		}
+	case []interface{}:
+		for _, nested := range v {
+			leaked = append(leaked, leakedKeys(nested, false, allowMinted)...)
+		}
+	}
+	return leaked
+}
+
+// call runs a request through the router and fails the test unless it succeeded without leaking.
+func call(t *testing.T, router *gin.Engine, method, path string, body interface{}, allowMinted bool) map[string]interface{} {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request := httptest.NewRequest(method, path, reader)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s %s: status %d: %s", method, path, recorder.Code, recorder.Body)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if leaked := leakedKeys(decoded, true, allowMinted); len(leaked) > 0 {
+		t.Errorf("%s %s leaks %v: %s", method, path, leaked, recorder.Body)
+	}
+	return decoded
+}
+
+// asViewer stands in for middleware.Authenticate, making the request as the given user.
+func asViewer(uid, userType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("uid", uid)
+		c.Set("user_type", userType)
+		c.Set("mfa_enabled", true)
+		c.Next()
+	}
+}
+
+func TestUserHandlersDoNotLeakSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	suffix := primitive.NewObjectID().Hex()
+	email := "responses-" + suffix + "@example.com"
+	password := "Correct-Horse-42-battery"
+	t.Cleanup(func() {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		userCollection.DeleteOne(ctx, bson.M{"email": email})
+	})
+
+	public := gin.New()
+	public.POST("/user/signup", Signup())
+	public.POST("/user/login", Login())
+
+	call(t, public, http.MethodPost, "/user/signup", gin.H{
+		"first_name": "Response",
+		"last_name":  "Checker",
+		"email":      email,
+		"password":   password,
+		"phone":      suffix,
+		"user_type":  "USER",
+	}, true)
+	login := call(t, public, http.MethodPost, "/user/login", gin.H{"email": email, "password": password}, true)
+	userID, _ := login["user_id"].(string)
+	if userID == "" {
+		t.Fatalf("login returned no user_id: %v", login)
+	}
+
+	for _, viewerType := range []string{"USER", "ADMIN"} {
+		authenticated := gin.New()
+		authenticated.Use(asViewer(userID, viewerType))
+		authenticated.GET("/users/:user_id", GetUser())
+		authenticated.GET("/users", GetUsers())
+
+		call(t, authenticated, http.MethodGet, "/users/"+userID, nil, false)
+		if viewerType == "ADMIN" {
+			call(t, authenticated, http.MethodGet, "/users", nil, false)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// webauthnFinishRequest is the body of the endpoints finishing a WebAuthn ceremony: the ceremony ID
+// returned when it began and the PublicKeyCredential produced by the browser, JSON encoded as is.
+type webauthnFinishRequest struct {
+	Ceremony_id *string         `json:"ceremony_id" validate:"required"`
+	Credential  json.RawMessage `json:"credential" validate:"required"`
+}
+
+// webauthnFailure responds to a ceremony that could not be finished. Invalid ceremonies and responses
+// that don't verify get the given client error status; anything else is a server error.
+func webauthnFailure(c *gin.Context, err error, status int) {
+	if err == helpers.ErrInvalidWebAuthnCeremony || err == helpers.ErrWebAuthnVerificationFailed {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the passkey"})
+}
+
+// stepUpRequest re-authenticates the caller before a change to their passkeys: their current
+// password, or a TOTP or recovery code.
+type stepUpRequest struct {
+	Current_password *string `json:"current_password"`
+	mfaCodeRequest
+}
+
+// requireStepUp loads the caller and checks the step-up in the request body. If it is missing or
+// wrong it responds and returns false.
+func requireStepUp(ctx context.Context, c *gin.Context) (models.User, bool) {
+	var body stepUpRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.User{}, false
+	}
+	if body.Current_password == nil && body.Code == "" && body.Recovery_code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "current password or a two-factor code is required"})
+		return models.User{}, false
+	}
+
+	user, err := findCurrentUser(ctx, c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while loading the user"})
+		return models.User{}, false
+	}
+	if body.Current_password != nil {
+		if passwordIsValid, _ := VerifyPassword(*body.Current_password, *user.Password); !passwordIsValid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current password is incorrect"})
+			return models.User{}, false
+		}
+		return user, true
+	}
+
+	valid, err := verifySecondFactor(ctx, user, body.mfaCodeRequest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the code"})
+		return models.User{}, false
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func BeginWebAuthnRegistration() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// A passkey is a way into the account, so a stolen access token alone must not be enough to
+		// add one.
+		user, ok := requireStepUp(ctx, c)
+		if !ok {
+			return
+		}
+
+		// Registration options for the caller, to pass to navigator.credentials.create().
+		creation, ceremonyID, err := helpers.BeginWebAuthnRegistration(user.User_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while starting the passkey registration"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ceremony_id": ceremonyID, "options": creation})
+	}
+}
+
+func FinishWebAuthnRegistration() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The request body carries the authenticator's response and an optional name for the passkey.
+		var body struct {
+			webauthnFinishRequest
+			Name string `json:"name" validate:"max=64"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		credential, err := helpers.FinishWebAuthnRegistration(c.GetString("uid"), *body.Ceremony_id, body.Name, body.Credential)
+		if err != nil {
+			webauthnFailure(c, err, http.StatusBadRequest)
+			return
+		}
+
+		c.JSON(http.StatusOK, credential)
+	}
+}
+
+func GetWebAuthnCredentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// List the caller's own passkeys.
+		credentials, err := helpers.ListWebAuthnCredentials(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing passkeys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"credentials": credentials})
+	}
+}
+
+func DeleteWebAuthnCredential() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// Removing a passkey needs the same step-up as adding one, so a stolen access token alone
+		// can't strip the owner's passkeys.
+		user, ok := requireStepUp(ctx, c)
+		if !ok {
+			return
+		}
+
+		// Users can only remove their own passkeys.
+		err := helpers.DeleteWebAuthnCredential(user.User_id, c.Param("id"))
+		if err == helpers.ErrWebAuthnCredentialNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while removing the passkey"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": "passkey removed"})
+	}
+}
+
+func BeginWebAuthnLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Passwordless login: any of the user's discoverable passkeys can answer the challenge.
+		assertion, ceremonyID, err := helpers.BeginWebAuthnLogin("")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while starting the passkey login"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ceremony_id": ceremonyID, "options": assertion})
+	}
+}
+
+func FinishWebAuthnLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body webauthnFinishRequest
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		// The assertion identifies the user through the passkey's user handle.
+		userID, err := helpers.FinishWebAuthnLogin("", *body.Ceremony_id, body.Credential)
+		if err != nil {
+			webauthnFailure(c, err, http.StatusUnauthorized)
+			return
+		}
+
+		var foundUser models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&foundUser); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": helpers.ErrWebAuthnVerificationFailed.Error()})
+			return
+		}
+
+		// A user-verified passkey is possession and knowledge (or biometrics) at once,
+		// so no further factor is asked for.
+		if refuseUnverifiedLogin(c, foundUser) {
+			return
+		}
+		issueLoginTokens(c, foundUser)
+	}
+}
+
+func BeginWebAuthnMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The request body carries the MFA pending token from the password step.
+		var body struct {
+			Mfa_token *string `json:"mfa_token" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		claims, msg := helpers.ValidateMFAPendingToken(*body.Mfa_token)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		// Only the user's own passkeys may answer the challenge.
+		assertion, ceremonyID, err := helpers.BeginWebAuthnLogin(claims.Uid)
+		if err == helpers.ErrWebAuthnCredentialNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no passkey is registered for this account"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while starting the passkey login"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ceremony_id": ceremonyID, "options": assertion})
+	}
+}
+
+func FinishWebAuthnMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// The request body carries the MFA pending token along with the authenticator's response.
+		var body struct {
+			Mfa_token *string `json:"mfa_token" validate:"required"`
+			webauthnFinishRequest
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validateErr := validate.Struct(body); validateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validateErr.Error()})
+			return
+		}
+
+		claims, foundUser, ok := beginMFAAttempt(ctx, c, *body.Mfa_token)
+		if !ok {
+			return
+		}
+
+		if _, err := helpers.FinishWebAuthnLogin(claims.Uid, *body.Ceremony_id, body.Credential); err != nil {
+			if err == helpers.ErrInvalidWebAuthnCeremony || err == helpers.ErrWebAuthnVerificationFailed {
+				failMFAAttempt(c, claims, foundUser, err.Error())
+				return
+			}
+			webauthnFailure(c, err, http.StatusUnauthorized)
+			return
+		}
+
+		finishMFAAttempt(c, claims, foundUser)
+	}
+}
@@ -0,0 +1,120 @@
+package helpers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Names of the cookies the token pair is set in when cookie mode is on.
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+)
+
+// refreshTokenCookiePath limits the refresh token cookie to the refresh endpoint.
+const refreshTokenCookiePath = "/user/refresh"
+
+// Cookie mode for browser apps, turned on with AUTH_COOKIES=on. Login and refresh then set the token
+// pair in HttpOnly cookies instead of returning it in the response body, and requests can authenticate
+// with the access token cookie. AUTH_COOKIE_SECURE defaults to whether APP_BASE_URL is https,
+// AUTH_COOKIE_SAMESITE is "lax" (default), "strict" or "none", and AUTH_COOKIE_DOMAIN is optional.
+// AUTH_REALM is the realm named in WWW-Authenticate challenges.
+var (
+	authCookies        = mustAuthCookies()
+	authCookieSecure   = envString("AUTH_COOKIE_SECURE", boolString(strings.HasPrefix(appBaseURL, "https://"))) == "true"
+	authCookieSameSite = mustAuthCookieSameSite()
+	authCookieDomain   = envString("AUTH_COOKIE_DOMAIN", "")
+	authRealm          = envString("AUTH_REALM", jwtIssuer)
+)
+
+// boolString formats a bool the way the boolean settings are written.
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// mustAuthCookies reads AUTH_COOKIES, defaulting to "off".
+func mustAuthCookies() bool {
+	switch mode := envString("AUTH_COOKIES", "off"); mode {
+	case "on":
+		return true
+	case "off":
+		return false
+	default:
+		log.Fatalf("invalid AUTH_COOKIES %q", mode)
+		return false
+	}
+}
+
+// mustAuthCookieSameSite reads AUTH_COOKIE_SAMESITE, defaulting to "lax".
+func mustAuthCookieSameSite() http.SameSite {
+	switch sameSite := envString("AUTH_COOKIE_SAMESITE", "lax"); sameSite {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		log.Fatalf("invalid AUTH_COOKIE_SAMESITE %q", sameSite)
+		return http.SameSiteDefaultMode
+	}
+}
+
+// AuthCookiesEnabled reports whether cookie mode is on.
+func AuthCookiesEnabled() bool {
+	return authCookies
+}
+
+// setAuthCookie sets or, with a negative maxAge, clears one of the token cookies.
+func setAuthCookie(c *gin.Context, name, value, path string, maxAge int) {
+	c.SetSameSite(authCookieSameSite)
+	c.SetCookie(name, value, maxAge, path, authCookieDomain, authCookieSecure, true)
+}
+
+// SetAuthCookies sets the token pair in cookies that expire together with the tokens.
+// It does nothing unless cookie mode is on.
+func SetAuthCookies(c *gin.Context, signedToken, signedRefreshToken string) {
+	if !authCookies {
+		return
+	}
+	for _, cookie := range []struct{ name, token, path string }{
+		{AccessTokenCookie, signedToken, "/"},
+		{RefreshTokenCookie, signedRefreshToken, refreshTokenCookiePath},
+	} {
+		claims, err := unverifiedClaims(cookie.token)
+		if err != nil {
+			continue
+		}
+		setAuthCookie(c, cookie.name, cookie.token, cookie.path, int(time.Until(time.Unix(claims.ExpiresAt, 0))/time.Second))
+	}
+}
+
+// ClearAuthCookies removes the token cookies. It does nothing unless cookie mode is on.
+func ClearAuthCookies(c *gin.Context) {
+	if !authCookies {
+		return
+	}
+	setAuthCookie(c, AccessTokenCookie, "", "/", -1)
+	setAuthCookie(c, RefreshTokenCookie, "", refreshTokenCookiePath, -1)
+}
+
+// BearerChallenge builds the WWW-Authenticate header value for a rejected request (RFC 6750 §3).
+// errorCode is "invalid_request", "invalid_token" or "insufficient_scope", or empty when the
+// request carried no credentials at all.
+func BearerChallenge(errorCode, description string) string {
+	challenge := `Bearer realm="` + authRealm + `"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `"`
+	}
+	if description != "" {
+		challenge += `, error_description="` + strings.ReplaceAll(description, `"`, `'`) + `"`
+	}
+	return challenge
+}
@@ -6,6 +6,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// ErrAdminMFARequired is returned when an administrator without a second factor uses an admin-only
+// resource. They can still enable TOTP or register a passkey, and get their admin rights at the next login.
+var ErrAdminMFARequired = errors.New("administrators must enable two-factor authentication and log in again to access this resource")
+
 // checkUserType checks if the user type from the context matches the required role.
 // Returns an error if the user type does not match the role.
 func CheckUserType(c *gin.Context, role string) (err error) {
@@ -19,6 +23,11 @@ func CheckUserType(c *gin.Context, role string) (err error) {
 		return err
 	}
 
+	// Administrators only act as such when their token was issued with a second factor.
+	if role == "ADMIN" && !c.GetBool("mfa_enabled") {
+		return ErrAdminMFARequired
+	}
+
 	// If the user type matches the role, return nil.
 	return err
 }
@@ -37,7 +46,9 @@ func MatchUserTypeToUid(c *gin.Context, userId string) (err error) {
 		return err
 	}
 
-	// Check if the user type matches the required role.
-	err = CheckUserType(c, userType)
+	// Reaching another user's data takes the admin role, second factor included.
+	if uid != userId {
+		err = CheckUserType(c, userType)
+	}
 	return err
 }
@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString reads a setting from the environment, falling back to def when it is unset.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envDuration reads a duration such as "90s" or "24h" from the environment, falling back to def.
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return d
+}
+
+// envInt reads an integer from the environment, falling back to def.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return n
+}
+
+// TrustedProxies reads TRUSTED_PROXIES, a comma separated list of the IP addresses or CIDR ranges
+// of the reverse proxies in front of the service. Only their X-Forwarded-For headers are believed
+// when working out the client IP, which the per-IP rate limits and login throttling are keyed by.
+// When it is unset no proxy is trusted and the client IP is the address of the connection.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Double-submit CSRF protection: the token is set in a cookie scripts on our origin can read, and
+// must be echoed in the CSRF header. A cross-site page can make the browser send the cookie but
+// can't read it, so it can't produce the header.
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// csrfTokenMaxAge keeps the CSRF cookie for as long as a refresh token lives.
+const csrfTokenMaxAge = 168 * 60 * 60
+
+// IssueCSRFToken returns the browser's CSRF token, setting a new one in the CSRF cookie if it has none.
+func IssueCSRFToken(c *gin.Context) (string, error) {
+	if token, err := c.Cookie(CSRFCookie); err == nil && len(token) >= 43 {
+		return token, nil
+	}
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	// Readable by scripts (not HttpOnly), so they can copy it into the header.
+	c.SetSameSite(authCookieSameSite)
+	c.SetCookie(CSRFCookie, token, csrfTokenMaxAge, "/", authCookieDomain, authCookieSecure, false)
+	return token, nil
+}
+
+// ValidCSRFToken reports whether the request's CSRF header matches its CSRF cookie.
+func ValidCSRFToken(c *gin.Context) bool {
+	cookie, err := c.Cookie(CSRFCookie)
+	if err != nil || cookie == "" {
+		return false
+	}
+	header := c.GetHeader(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
@@ -0,0 +1,147 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Email verification policies, chosen with EMAIL_VERIFICATION_POLICY.
+const (
+	// EmailVerificationOff sends no verification email and treats every account alike.
+	EmailVerificationOff = "off"
+	// EmailVerificationRestrict lets unverified accounts log in, but with access limited to
+	// routes that don't require a verified email.
+	EmailVerificationRestrict = "restrict"
+	// EmailVerificationRequire refuses to log unverified accounts in.
+	EmailVerificationRequire = "require"
+)
+
+// ErrInvalidVerificationToken is returned for a verification link that is invalid, expired or already used.
+var ErrInvalidVerificationToken = errors.New("verification link is invalid, expired or has already been used")
+
+// Initializes `emailVerificationCollection` to access the "email_verification" MongoDB collection.
+var emailVerificationCollection *mongo.Collection = database.OpenCollection(database.Client, "email_verification")
+
+var (
+	emailVerificationPolicy = mustEmailVerificationPolicy()
+	emailVerificationTTL    = envDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	// appBaseURL is the public URL of this service, used to build links sent by email.
+	appBaseURL = envString("APP_BASE_URL", "http://localhost:9000")
+)
+
+// mustEmailVerificationPolicy reads EMAIL_VERIFICATION_POLICY, defaulting to "off" so existing
+// deployments keep working unchanged. Verification is opt-in: set "restrict" or "require" once a
+// real Mailer is configured, since with "require" nobody can log in who can't receive the link.
+func mustEmailVerificationPolicy() string {
+	policy := envString("EMAIL_VERIFICATION_POLICY", EmailVerificationOff)
+	switch policy {
+	case EmailVerificationOff, EmailVerificationRestrict, EmailVerificationRequire:
+		return policy
+	}
+	log.Fatalf("invalid EMAIL_VERIFICATION_POLICY %q", policy)
+	return ""
+}
+
+// EmailVerificationPolicy returns the configured email verification policy.
+func EmailVerificationPolicy() string {
+	return emailVerificationPolicy
+}
+
+// SendVerificationEmail mails the user a single-use link that verifies their email address.
+// Earlier pending verifications are dropped and the new token is stored hashed. Within the email
+// cooldown of the last link to the same address nothing is sent and that link stays valid.
+func SendVerificationEmail(uid, email string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if recent, err := sentRecently(ctx, emailVerificationCollection, bson.M{"user_id": uid, "email": email}); err != nil || recent {
+		return err
+	}
+
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent link works.
+	if _, err := emailVerificationCollection.DeleteMany(ctx, bson.M{"user_id": uid}); err != nil {
+		return err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err = emailVerificationCollection.InsertOne(ctx, models.EmailVerification{
+		ID:         primitive.NewObjectID(),
+		Token_hash: HashToken(token),
+		User_id:    uid,
+		Email:      email,
+		Expires_at: now.Add(emailVerificationTTL),
+		Created_at: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL + "/user/verify-email?token=" + url.QueryEscape(token)
+	body := "Please confirm your email address by opening the link below.\n\n" + link +
+		"\n\nThe link expires in " + emailVerificationTTL.String() + ". If you didn't sign up, you can ignore this email."
+	return SendMail(email, "Verify your email address", body)
+}
+
+// VerifyEmail redeems a verification link token and marks the user's email address as verified.
+// The token only verifies the address it was issued for, so changing the email invalidates it.
+func VerifyEmail(token string) error {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	// Each link works once: the pending verification is deleted as it is redeemed.
+	var verification models.EmailVerification
+	err := emailVerificationCollection.FindOneAndDelete(
+		ctx,
+		bson.M{"token_hash": HashToken(token), "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&verification)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	result, err := userCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": verification.User_id, "email": verification.Email},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "email_verified", Value: true},
+			{Key: "updated_at", Value: updatedAt},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
+
+// ensureEmailVerificationIndexes indexes the token fingerprint and lets MongoDB drop expired verifications.
+func ensureEmailVerificationIndexes(ctx context.Context) error {
+	_, err := emailVerificationCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -0,0 +1,23 @@
+package helpers
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// LogSecurityEvent writes a security-relevant event (token reuse, revocation, ...) to the log
+// as a single JSON line so it can be picked up by log-based alerting.
+func LogSecurityEvent(event string, fields map[string]interface{}) {
+	// Copy the fields so the caller's map is left untouched.
+	entry := map[string]interface{}{"event": event}
+	for key, value := range fields {
+		entry[key] = value
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("security event %s: %v", event, fields)
+		return
+	}
+	log.Printf("security event %s", line)
+}
@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"context"
+	"time"
+)
+
+// EnsureIndexes creates the MongoDB indexes the helpers rely on. It is safe to call on every start.
+func EnsureIndexes() error {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if err := ensureRevocationIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureSessionIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureEmailVerificationIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensurePasswordResetIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureWebAuthnIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureMagicLinkIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureLoginAttemptIndexes(ctx); err != nil {
+		return err
+	}
+	return ensureRateLimitIndexes(ctx)
+}
@@ -0,0 +1,118 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+// JWK is the public half of a signing key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK converts the verification key of an asymmetric KeyProvider to a JWK.
+// It returns false for HMAC providers, whose shared secret must never be published.
+func PublicJWK(provider KeyProvider) (JWK, bool) {
+	jwk, ok := publicJWK(provider.VerificationKey())
+	if !ok {
+		return JWK{}, false
+	}
+	jwk.Kid = provider.KeyID()
+	jwk.Use = "sig"
+	jwk.Alg = provider.SigningMethod().Alg()
+	return jwk, true
+}
+
+// publicJWK fills in the key type specific members of a JWK.
+func publicJWK(key interface{}) (JWK, bool) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encodeJWKInt(k.N.Bytes()),
+			E:   encodeJWKInt(big.NewInt(int64(k.E)).Bytes()),
+		}, true
+	case *ecdsa.PublicKey:
+		// Coordinates are left-padded to the curve size as required by RFC 7518.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   encodeJWKInt(k.X.FillBytes(make([]byte, size))),
+			Y:   encodeJWKInt(k.Y.FillBytes(make([]byte, size))),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: encodeJWKInt(k)}, true
+	}
+	return JWK{}, false
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint of a public key, used as its default key ID.
+func jwkThumbprint(key interface{}) string {
+	jwk, ok := publicJWK(key)
+	if !ok {
+		return ""
+	}
+
+	// The thumbprint covers only the required members, in lexicographic order.
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	encoded, _ := json.Marshal(members)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// encodeJWKInt base64url encodes a big-endian value without padding.
+func encodeJWKInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// JWKS returns the public keys tokens may currently be verified with.
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, provider := range verificationKeys() {
+		if jwk, ok := PublicJWK(provider); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
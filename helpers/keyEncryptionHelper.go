@@ -0,0 +1,104 @@
+package helpers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// encryptedKeyPrefix marks key material sealed with the key encryption key.
+const encryptedKeyPrefix = "enc:v1:"
+
+// signingKeyKEK is the key encryption key (KEK) the key ring's private keys and HMAC secrets are
+// sealed with before they are stored, so read access to the database is not enough to mint tokens.
+// Users' TOTP secrets are sealed with it too, so it isn't enough to generate their codes either.
+// SIGNING_KEY_ENCRYPTION_KEY holds it as 32 base64 encoded random bytes, e.g. `openssl rand -base64 32`.
+var signingKeyKEK = mustSigningKeyKEK()
+
+// mustSigningKeyKEK reads SIGNING_KEY_ENCRYPTION_KEY, failing fast if it is missing or malformed.
+func mustSigningKeyKEK() cipher.AEAD {
+	encoded := os.Getenv("SIGNING_KEY_ENCRYPTION_KEY")
+	if encoded == "" {
+		log.Fatal("SIGNING_KEY_ENCRYPTION_KEY is required to encrypt the signing keys stored in MongoDB")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		log.Fatal("invalid SIGNING_KEY_ENCRYPTION_KEY, expected 32 base64 encoded bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return aead
+}
+
+// encryptKeyMaterial seals key material with AES-256-GCM under the KEK. The kid is bound in as
+// additional data, so a sealed key can't be swapped into another key ring entry.
+func encryptKeyMaterial(kid, material string) (string, error) {
+	nonce := make([]byte, signingKeyKEK.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := signingKeyKEK.Seal(nonce, nonce, []byte(material), []byte(kid))
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptKeyMaterial opens key material sealed by encryptKeyMaterial. Material stored before
+// encryption was introduced is returned as is; encryptStoredSigningKeys seals it at startup.
+func decryptKeyMaterial(kid, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedKeyPrefix) {
+		return stored, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedKeyPrefix))
+	if err != nil || len(sealed) < signingKeyKEK.NonceSize() {
+		return "", errors.New("malformed encrypted key material")
+	}
+	nonce, ciphertext := sealed[:signingKeyKEK.NonceSize()], sealed[signingKeyKEK.NonceSize():]
+	material, err := signingKeyKEK.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return "", errors.New("key material can't be decrypted, check SIGNING_KEY_ENCRYPTION_KEY")
+	}
+	return string(material), nil
+}
+
+// encryptStoredSigningKeys seals key material that older versions stored in plaintext.
+func encryptStoredSigningKeys(ctx context.Context) error {
+	cursor, err := signingKeyCollection.Find(ctx, bson.M{"private_key": bson.M{"$not": primitive.Regex{Pattern: "^" + encryptedKeyPrefix}}})
+	if err != nil {
+		return err
+	}
+	var keys []models.SigningKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		sealed, err := encryptKeyMaterial(key.Kid, key.Private_key)
+		if err != nil {
+			return err
+		}
+		_, err = signingKeyCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": key.ID, "private_key": key.Private_key},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "private_key", Value: sealed}}}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// KeyProvider supplies the algorithm and keys used to sign and verify tokens.
+type KeyProvider interface {
+	// KeyID returns the identifier written to the `kid` header of every token signed with this key.
+	KeyID() string
+	// SigningMethod returns the JWT algorithm tokens are signed with.
+	SigningMethod() jwt.SigningMethod
+	// SigningKey returns the key handed to the signing method when minting tokens.
+	SigningKey() interface{}
+	// VerificationKey returns the key used to check signatures (the public half for asymmetric algorithms).
+	VerificationKey() interface{}
+}
+
+// hmacKeyProvider signs and verifies with a shared secret (HS256/HS384/HS512).
+type hmacKeyProvider struct {
+	kid    string
+	method *jwt.SigningMethodHMAC
+	secret []byte
+}
+
+func (p *hmacKeyProvider) KeyID() string                    { return p.kid }
+func (p *hmacKeyProvider) SigningMethod() jwt.SigningMethod { return p.method }
+func (p *hmacKeyProvider) SigningKey() interface{}          { return p.secret }
+func (p *hmacKeyProvider) VerificationKey() interface{}     { return p.secret }
+
+// asymmetricKeyProvider signs with a private key and verifies with its public half.
+type asymmetricKeyProvider struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+}
+
+func (p *asymmetricKeyProvider) KeyID() string                    { return p.kid }
+func (p *asymmetricKeyProvider) SigningMethod() jwt.SigningMethod { return p.method }
+func (p *asymmetricKeyProvider) SigningKey() interface{}          { return p.privateKey }
+func (p *asymmetricKeyProvider) VerificationKey() interface{}     { return p.privateKey.Public() }
+
+// NewHMACKeyProvider returns a KeyProvider for the given HMAC algorithm and shared secret.
+// An empty kid is replaced by one derived from a hash of the secret.
+func NewHMACKeyProvider(kid, algorithm, secret string) (KeyProvider, error) {
+	method, ok := jwt.GetSigningMethod(algorithm).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an HMAC algorithm", algorithm)
+	}
+	if secret == "" {
+		return nil, errors.New("an HMAC algorithm requires a non-empty SECRET_KEY")
+	}
+	if kid == "" {
+		sum := sha256.Sum256([]byte("hmac:" + secret))
+		kid = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+	return &hmacKeyProvider{kid: kid, method: method, secret: []byte(secret)}, nil
+}
+
+// NewAsymmetricKeyProvider parses a PEM encoded private key (PKCS#8, PKCS#1 or SEC 1)
+// and returns a KeyProvider for the given algorithm. The key type must match the algorithm.
+// An empty kid is replaced by the RFC 7638 thumbprint of the public key.
+func NewAsymmetricKeyProvider(kid, algorithm string, pemBytes []byte) (KeyProvider, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	privateKey, err := parsePrivateKeyPEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the key can actually be used with the configured algorithm.
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := privateKey.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("%s requires an RSA private key", algorithm)
+		}
+	case *jwt.SigningMethodECDSA:
+		ecKey, ok := privateKey.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve.Params().BitSize != m.CurveBits {
+			return nil, fmt.Errorf("%s requires an ECDSA private key on a %d-bit curve", algorithm, m.CurveBits)
+		}
+	case *signingMethodEdDSA:
+		if _, ok := privateKey.(ed25519.PrivateKey); !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 private key", algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not an asymmetric algorithm", algorithm)
+	}
+
+	if kid == "" {
+		kid = jwkThumbprint(privateKey.Public())
+	}
+	return &asymmetricKeyProvider{kid: kid, method: method, privateKey: privateKey}, nil
+}
+
+// parsePrivateKeyPEM decodes the first PEM block and parses it as a PKCS#8, PKCS#1 or SEC 1 private key.
+func parsePrivateKeyPEM(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported PKCS#8 private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unable to parse private key: expected PKCS#8, PKCS#1 or EC PEM")
+}
+
+// LoadKeyProvider builds the KeyProvider described by the environment:
+// JWT_ALGORITHM selects the algorithm (HS256 by default), HMAC algorithms use SECRET_KEY,
+// and every other algorithm loads its private key from the PEM file at JWT_PRIVATE_KEY_FILE.
+// JWT_KEY_ID overrides the derived key ID.
+func LoadKeyProvider() (KeyProvider, error) {
+	kid := os.Getenv("JWT_KEY_ID")
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS256.Alg()
+	}
+
+	if _, ok := jwt.GetSigningMethod(algorithm).(*jwt.SigningMethodHMAC); ok {
+		return NewHMACKeyProvider(kid, algorithm, SECRET_KEY)
+	}
+
+	keyFile := os.Getenv("JWT_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE must be set when JWT_ALGORITHM is %s", algorithm)
+	}
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewAsymmetricKeyProvider(kid, algorithm, pemBytes)
+}
+
+// GenerateKeyProvider creates a KeyProvider with fresh key material for the given algorithm:
+// a 256-bit secret for HMAC, a 2048-bit RSA key, an ECDSA key on the matching curve or an Ed25519 key.
+func GenerateKeyProvider(algorithm string) (KeyProvider, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch m := jwt.GetSigningMethod(algorithm).(type) {
+	case *jwt.SigningMethodHMAC:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACKeyProvider("", algorithm, base64.RawURLEncoding.EncodeToString(secret))
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case *jwt.SigningMethodECDSA:
+		curves := map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		privateKey, err = ecdsa.GenerateKey(curves[m.CurveBits], rand.Reader)
+	case *signingMethodEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewAsymmetricKeyProvider("", algorithm, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// marshalKeyMaterial returns the secret (HMAC) or PKCS#8 PEM private key (asymmetric) of a provider
+// in the form NewHMACKeyProvider and NewAsymmetricKeyProvider accept back.
+func marshalKeyMaterial(provider KeyProvider) (string, error) {
+	switch p := provider.(type) {
+	case *hmacKeyProvider:
+		return string(p.secret), nil
+	case *asymmetricKeyProvider:
+		der, err := x509.MarshalPKCS8PrivateKey(p.privateKey)
+		if err != nil {
+			return "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	}
+	return "", errors.New("unsupported key provider")
+}
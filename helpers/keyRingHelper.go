@@ -0,0 +1,322 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Key ring states.
+const (
+	KeyStatusActive     = "ACTIVE"
+	KeyStatusVerifyOnly = "VERIFY_ONLY"
+	KeyStatusRetired    = "RETIRED"
+)
+
+// ErrSigningKeyNotFound is returned when a key ring operation names an unknown kid.
+var ErrSigningKeyNotFound = errors.New("signing key not found")
+
+// Initializes `signingKeyCollection` to access the "signing_key" MongoDB collection.
+var signingKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "signing_key")
+
+// keyRingRefreshInterval is how long a loaded key ring is trusted before it is re-read,
+// so key changes made through another replica are picked up.
+var keyRingRefreshInterval = envDuration("KEY_RING_REFRESH_INTERVAL", time.Minute)
+
+// keyRotationGracePeriod is how long a demoted key keeps verifying tokens. It defaults to the
+// refresh token lifetime so no token signed by the old key is cut short.
+var keyRotationGracePeriod = envDuration("KEY_ROTATION_GRACE_PERIOD", 168*time.Hour)
+
+// keyRing caches the active signing key and the keys tokens may be verified with.
+type keyRing struct {
+	mu       sync.RWMutex
+	active   KeyProvider
+	verify   []KeyProvider
+	loadedAt time.Time
+}
+
+var ring = mustLoadKeyRing()
+
+// mustLoadKeyRing loads the key ring at startup, seeding it with the key configured through
+// the environment when no active key has been stored yet.
+func mustLoadKeyRing() *keyRing {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if err := encryptStoredSigningKeys(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := signingKeyCollection.CountDocuments(ctx, bson.M{"status": KeyStatusActive})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if count == 0 {
+		provider, err := LoadKeyProvider()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := storeSigningKey(ctx, provider, KeyStatusActive); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	r := &keyRing{}
+	if err := r.reload(ctx); err != nil {
+		log.Fatal(err)
+	}
+	return r
+}
+
+// storeSigningKey persists a provider's key material, encrypted under the KEK, in the key ring with the given status.
+func storeSigningKey(ctx context.Context, provider KeyProvider, status string) (models.SigningKey, error) {
+	material, err := marshalKeyMaterial(provider)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	material, err = encryptKeyMaterial(provider.KeyID(), material)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	key := models.SigningKey{
+		ID:          primitive.NewObjectID(),
+		Kid:         provider.KeyID(),
+		Algorithm:   provider.SigningMethod().Alg(),
+		Private_key: material,
+		Status:      status,
+		Created_at:  now,
+		Updated_at:  now,
+	}
+
+	_, err = signingKeyCollection.InsertOne(ctx, key)
+	return key, err
+}
+
+// providerFor rebuilds the KeyProvider of a stored key.
+func providerFor(key models.SigningKey) (KeyProvider, error) {
+	material, err := decryptKeyMaterial(key.Kid, key.Private_key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := jwt.GetSigningMethod(key.Algorithm).(*jwt.SigningMethodHMAC); ok {
+		return NewHMACKeyProvider(key.Kid, key.Algorithm, material)
+	}
+	return NewAsymmetricKeyProvider(key.Kid, key.Algorithm, []byte(material))
+}
+
+// reload re-reads the key ring from MongoDB. Verify-only keys whose grace period has ended
+// are retired on the way.
+func (r *keyRing) reload(ctx context.Context) error {
+	now := time.Now()
+
+	_, err := signingKeyCollection.UpdateMany(
+		ctx,
+		bson.M{"status": KeyStatusVerifyOnly, "verify_until": bson.M{"$lte": now}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: KeyStatusRetired},
+			{Key: "updated_at", Value: now},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Newest keys first, so the most recently promoted key wins if two are briefly ACTIVE.
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	cursor, err := signingKeyCollection.Find(ctx, bson.M{"status": bson.M{"$ne": KeyStatusRetired}}, opts)
+	if err != nil {
+		return err
+	}
+	var keys []models.SigningKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return err
+	}
+
+	var active KeyProvider
+	var verify []KeyProvider
+	for _, key := range keys {
+		provider, err := providerFor(key)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.Kid, err)
+		}
+		if key.Status == KeyStatusActive && active == nil {
+			active = provider
+		}
+		verify = append(verify, provider)
+	}
+	if active == nil {
+		return errors.New("key ring has no active signing key")
+	}
+
+	r.mu.Lock()
+	r.active, r.verify, r.loadedAt = active, verify, now
+	r.mu.Unlock()
+	return nil
+}
+
+// current returns the active key and the verification keys, reloading the ring if it is stale.
+// A failed reload keeps serving the previously loaded keys.
+func (r *keyRing) current() (KeyProvider, []KeyProvider) {
+	r.mu.RLock()
+	stale := time.Since(r.loadedAt) > keyRingRefreshInterval
+	r.mu.RUnlock()
+
+	if stale {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := r.reload(ctx); err != nil {
+			log.Println("key ring reload failed:", err)
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active, r.verify
+}
+
+// verificationKeys returns every key a presented token may be signed with.
+func verificationKeys() []KeyProvider {
+	_, verify := ring.current()
+	return verify
+}
+
+// verificationKeyFor selects the key a token is verified with from its `kid` header.
+// Tokens minted before key IDs were introduced carry no `kid` and fall back to the active key.
+func verificationKeyFor(token *jwt.Token) (interface{}, error) {
+	active, verify := ring.current()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = active.KeyID()
+	}
+
+	for _, provider := range verify {
+		if provider.KeyID() != kid {
+			continue
+		}
+		// Only accept the key's own algorithm, so a token can't pick a weaker one (e.g. HS256 keyed with a public key).
+		if token.Method.Alg() != provider.SigningMethod().Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return provider.VerificationKey(), nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// signToken signs the claims with the active key and stamps its `kid` header.
+func signToken(claims jwt.Claims) (string, error) {
+	active, _ := ring.current()
+	token := jwt.NewWithClaims(active.SigningMethod(), claims)
+	token.Header["kid"] = active.KeyID()
+	return token.SignedString(active.SigningKey())
+}
+
+// ListSigningKeys returns every key in the ring, newest first. Key material is never serialized.
+func ListSigningKeys() ([]models.SigningKey, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := signingKeyCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := []models.SigningKey{}
+	err = cursor.All(ctx, &keys)
+	return keys, err
+}
+
+// AddSigningKey generates a new key for the algorithm and adds it as VERIFY_ONLY, so it is
+// published in the JWKS before it starts signing. Promote it once verifiers have picked it up.
+func AddSigningKey(algorithm string) (models.SigningKey, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	provider, err := GenerateKeyProvider(algorithm)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	key, err := storeSigningKey(ctx, provider, KeyStatusVerifyOnly)
+	if err != nil {
+		return key, err
+	}
+	return key, ring.reload(ctx)
+}
+
+// PromoteSigningKey makes the key the active signing key. The previously active key becomes
+// VERIFY_ONLY until the rotation grace period ends, so tokens it signed stay valid.
+func PromoteSigningKey(kid string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	// Promote first: until the old key is demoted the newest ACTIVE key wins.
+	result, err := signingKeyCollection.UpdateOne(
+		ctx,
+		bson.M{"kid": kid, "status": bson.M{"$ne": KeyStatusRetired}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "status", Value: KeyStatusActive}, {Key: "updated_at", Value: now}}},
+			{Key: "$unset", Value: bson.D{{Key: "verify_until", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSigningKeyNotFound
+	}
+
+	_, err = signingKeyCollection.UpdateMany(
+		ctx,
+		bson.M{"kid": bson.M{"$ne": kid}, "status": KeyStatusActive},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: KeyStatusVerifyOnly},
+			{Key: "verify_until", Value: now.Add(keyRotationGracePeriod)},
+			{Key: "updated_at", Value: now},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	return ring.reload(ctx)
+}
+
+// RetireSigningKey stops trusting a key immediately. The active key can't be retired;
+// promote another key first.
+func RetireSigningKey(kid string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var key models.SigningKey
+	if err := signingKeyCollection.FindOne(ctx, bson.M{"kid": kid}).Decode(&key); err != nil {
+		return ErrSigningKeyNotFound
+	}
+	if key.Status == KeyStatusActive {
+		return errors.New("the active signing key can't be retired, promote another key first")
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := signingKeyCollection.UpdateOne(
+		ctx,
+		bson.M{"kid": kid},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: KeyStatusRetired}, {Key: "updated_at", Value: now}}}},
+	)
+	if err != nil {
+		return err
+	}
+	return ring.reload(ctx)
+}
@@ -0,0 +1,224 @@
+package helpers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Initializes `loginAttemptCollection` to access the "login_attempt" MongoDB collection.
+var loginAttemptCollection *mongo.Collection = database.OpenCollection(database.Client, "login_attempt")
+
+// loginThrottlePolicy is how failed logins are throttled for one kind of key. After backoffAfter
+// failures each further attempt has to wait twice as long as the previous one, and after lockoutAfter
+// failures the key is locked out altogether.
+type loginThrottlePolicy struct {
+	prefix       string
+	event        string
+	backoffAfter int
+	lockoutAfter int
+}
+
+// Failed logins are counted per account and per client IP. A client IP gets far more attempts,
+// since many users can share one address. LOGIN_BACKOFF_BASE is the first delay, doubling up to
+// LOGIN_BACKOFF_MAX; LOGIN_ATTEMPT_WINDOW is how long failures are remembered.
+var (
+	accountLoginPolicy = loginThrottlePolicy{
+		prefix:       "account:",
+		event:        "account_locked",
+		backoffAfter: envInt("LOGIN_ACCOUNT_BACKOFF_AFTER", 3),
+		lockoutAfter: envInt("LOGIN_ACCOUNT_LOCKOUT_AFTER", 10),
+	}
+	ipLoginPolicy = loginThrottlePolicy{
+		prefix:       "ip:",
+		event:        "ip_locked",
+		backoffAfter: envInt("LOGIN_IP_BACKOFF_AFTER", 20),
+		lockoutAfter: envInt("LOGIN_IP_LOCKOUT_AFTER", 100),
+	}
+	loginBackoffBase     = envDuration("LOGIN_BACKOFF_BASE", time.Second)
+	loginBackoffMax      = envDuration("LOGIN_BACKOFF_MAX", 5*time.Minute)
+	loginLockoutDuration = envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	loginAttemptWindow   = envDuration("LOGIN_ATTEMPT_WINDOW", time.Hour)
+)
+
+// loginThrottleKey returns the key failures are counted under for the given policy.
+func loginThrottleKey(policy loginThrottlePolicy, value string) string {
+	return policy.prefix + strings.ToLower(strings.TrimSpace(value))
+}
+
+// retryAfter returns how long the key has to wait before its next attempt, or 0 if it may try now.
+func (policy loginThrottlePolicy) retryAfter(attempt models.LoginAttempt, now time.Time) time.Duration {
+	blockedUntil := time.Time{}
+	if attempt.Locked_until != nil {
+		blockedUntil = *attempt.Locked_until
+	}
+
+	if attempt.Failures >= policy.backoffAfter {
+		delay := loginBackoffBase
+		for i := policy.backoffAfter; i < attempt.Failures && delay < loginBackoffMax; i++ {
+			delay *= 2
+		}
+		if delay > loginBackoffMax {
+			delay = loginBackoffMax
+		}
+		if backoffUntil := attempt.Last_failure_at.Add(delay); backoffUntil.After(blockedUntil) {
+			blockedUntil = backoffUntil
+		}
+	}
+
+	if wait := blockedUntil.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// BeginLoginAttempt counts a login attempt for the email from the client IP before the credentials
+// are checked, and returns how long it has to wait because of earlier failures, or 0 if it may go on.
+// Every attempt is counted up front with one atomic increment per key, and the wait is computed from
+// the state before that increment, so concurrent attempts can't all slip past the same count. A key
+// whose count reaches its lockout threshold is locked out. A refused attempt isn't counted.
+// An attempt that goes on must end with RecordLoginFailure or ReleaseLoginAttempt.
+func BeginLoginAttempt(email, ip string) (time.Duration, error) {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	attempts := []struct {
+		policy   loginThrottlePolicy
+		key      string
+		fields   map[string]interface{}
+		previous models.LoginAttempt
+	}{
+		{policy: accountLoginPolicy, key: loginThrottleKey(accountLoginPolicy, email), fields: map[string]interface{}{"email": email, "ip_address": ip}},
+		{policy: ipLoginPolicy, key: loginThrottleKey(ipLoginPolicy, ip), fields: map[string]interface{}{"ip_address": ip}},
+	}
+
+	// Count the attempt and read back the state before it. The longest wait of the account and the IP applies.
+	var wait time.Duration
+	for i := range attempts {
+		err := loginAttemptCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"key": attempts[i].key},
+			bson.D{
+				{Key: "$inc", Value: bson.D{{Key: "failures", Value: 1}}},
+				{Key: "$max", Value: bson.D{{Key: "expires_at", Value: now.Add(loginAttemptWindow)}}},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID()}}},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+		).Decode(&attempts[i].previous)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, err
+		}
+		if w := attempts[i].policy.retryAfter(attempts[i].previous, now); w > wait {
+			wait = w
+		}
+	}
+
+	if wait > 0 {
+		for _, attempt := range attempts {
+			if err := uncountAttempt(ctx, attempt.key); err != nil {
+				return 0, err
+			}
+		}
+		return wait, nil
+	}
+
+	for _, attempt := range attempts {
+		if failures := attempt.previous.Failures + 1; failures >= attempt.policy.lockoutAfter {
+			if err := lockOut(ctx, attempt.policy, attempt.key, failures, now, attempt.fields); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 0, nil
+}
+
+// RecordLoginFailure marks a login attempt started with BeginLoginAttempt as failed, so further
+// attempts for the email and from the client IP back off from now.
+func RecordLoginFailure(email, ip string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	_, err := loginAttemptCollection.UpdateMany(
+		ctx,
+		bson.M{"key": bson.M{"$in": bson.A{loginThrottleKey(accountLoginPolicy, email), loginThrottleKey(ipLoginPolicy, ip)}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "last_failure_at", Value: time.Now()}}}},
+	)
+	return err
+}
+
+// ReleaseLoginAttempt takes a login attempt started with BeginLoginAttempt whose credentials were
+// right off the client IP's count. The account's count is only cleared by ResetLoginFailures once
+// the whole login, including any second factor, has succeeded.
+func ReleaseLoginAttempt(ip string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	return uncountAttempt(ctx, loginThrottleKey(ipLoginPolicy, ip))
+}
+
+// uncountAttempt takes back one counted attempt of a key.
+func uncountAttempt(ctx context.Context, key string) error {
+	_, err := loginAttemptCollection.UpdateOne(
+		ctx,
+		bson.M{"key": key, "failures": bson.M{"$gt": 0}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "failures", Value: -1}}}},
+	)
+	return err
+}
+
+// lockOut locks a key out once its count reached the threshold, unless a concurrent attempt already did.
+func lockOut(ctx context.Context, policy loginThrottlePolicy, key string, failures int, now time.Time, fields map[string]interface{}) error {
+	lockedUntil := now.Add(loginLockoutDuration)
+	result, err := loginAttemptCollection.UpdateOne(
+		ctx,
+		bson.M{"key": key, "$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": nil},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "locked_until", Value: lockedUntil},
+			{Key: "expires_at", Value: lockedUntil.Add(loginAttemptWindow)},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 1 {
+		fields["failures"] = failures
+		fields["locked_until"] = lockedUntil
+		LogSecurityEvent(policy.event, fields)
+	}
+	return nil
+}
+
+// ResetLoginFailures forgets the failed logins of an account after a successful login.
+// The client IP's counter is left alone, so one valid account can't be used to reset it.
+func ResetLoginFailures(email string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	_, err := loginAttemptCollection.DeleteOne(ctx, bson.M{"key": loginThrottleKey(accountLoginPolicy, email)})
+	return err
+}
+
+// ensureLoginAttemptIndexes makes the keys unique and lets MongoDB drop counters once they expire.
+func ensureLoginAttemptIndexes(ctx context.Context) error {
+	_, err := loginAttemptCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
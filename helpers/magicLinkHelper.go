@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MagicLinkNonceCookie is the cookie binding a magic link to the browser that requested it.
+const MagicLinkNonceCookie = "magic_link_nonce"
+
+// magicLinkCookiePath limits the nonce cookie to the magic link endpoints.
+const magicLinkCookiePath = "/user/login/magic-link"
+
+// ErrInvalidMagicLink is returned for a magic link that is unknown, expired, already used,
+// or opened in a browser other than the one that requested it.
+var ErrInvalidMagicLink = errors.New("sign-in link is invalid, expired or has already been used")
+
+// Initializes `magicLinkCollection` to access the "magic_link" MongoDB collection.
+var magicLinkCollection *mongo.Collection = database.OpenCollection(database.Client, "magic_link")
+
+// magicLinkTTL is how long a sign-in link stays valid.
+var magicLinkTTL = envDuration("MAGIC_LINK_TTL", 15*time.Minute)
+
+// SendMagicLink emails the user a single-use sign-in link. The link only works together with the
+// nonce, which the caller sets as a cookie in the requesting browser with SetMagicLinkNonceCookie.
+// Earlier pending links of the user are dropped. Within the email cooldown of the last link
+// nothing is sent and that link stays valid.
+func SendMagicLink(userID, email, nonce string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if recent, err := sentRecently(ctx, magicLinkCollection, bson.M{"user_id": userID}); err != nil || recent {
+		return err
+	}
+
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent link works.
+	if _, err := magicLinkCollection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err = magicLinkCollection.InsertOne(ctx, models.MagicLink{
+		ID:         primitive.NewObjectID(),
+		Token_hash: HashToken(token),
+		Nonce_hash: HashToken(nonce),
+		User_id:    userID,
+		Email:      email,
+		Expires_at: now.Add(magicLinkTTL),
+		Created_at: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL + "/user/login/magic-link/callback?token=" + url.QueryEscape(token)
+	body := "Use the link below to sign in. It only works in the browser you requested it from.\n\n" + link +
+		"\n\nThe link expires in " + magicLinkTTL.String() + ". If you didn't try to sign in, you can ignore this email."
+	return SendMail(email, "Your sign-in link", body)
+}
+
+// ConsumeMagicLink redeems a sign-in link and returns the link it was. The link is deleted in the
+// same operation, so it works only once, even when it is opened without the matching nonce.
+func ConsumeMagicLink(token, nonce string) (models.MagicLink, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var link models.MagicLink
+	err := magicLinkCollection.FindOneAndDelete(
+		ctx,
+		bson.M{"token_hash": HashToken(token), "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return link, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return link, err
+	}
+
+	// The link must be opened in the browser that asked for it.
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(HashToken(nonce)), []byte(link.Nonce_hash)) != 1 {
+		LogSecurityEvent("magic_link_nonce_mismatch", map[string]interface{}{"user_id": link.User_id})
+		return link, ErrInvalidMagicLink
+	}
+	return link, nil
+}
+
+// SetMagicLinkNonceCookie stores the nonce of a requested magic link in the browser.
+func SetMagicLinkNonceCookie(c *gin.Context, nonce string) {
+	c.SetSameSite(http.SameSiteLaxMode) // Sent along when the link is opened from an email client
+	c.SetCookie(MagicLinkNonceCookie, nonce, int(magicLinkTTL/time.Second), magicLinkCookiePath, "", strings.HasPrefix(appBaseURL, "https://"), true)
+}
+
+// ClearMagicLinkNonceCookie removes the nonce cookie once its link has been used.
+func ClearMagicLinkNonceCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(MagicLinkNonceCookie, "", -1, magicLinkCookiePath, "", strings.HasPrefix(appBaseURL, "https://"), true)
+}
+
+// ensureMagicLinkIndexes indexes the token fingerprint and lets MongoDB drop expired links.
+func ensureMagicLinkIndexes(ctx context.Context) error {
+	_, err := magicLinkCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
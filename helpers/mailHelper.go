@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mailer delivers transactional email such as verification links.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer is the local Mailer: instead of sending email it appends each message to a file,
+// or writes it to the log when no file is configured. It lets the email flows be tested without SMTP.
+type LogMailer struct {
+	mu   sync.Mutex
+	Path string
+}
+
+// Send writes the message to the mail file or the log.
+func (m *LogMailer) Send(to, subject, body string) error {
+	message := fmt.Sprintf("Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+
+	if m.Path == "" {
+		log.Print("mail:\n" + message)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(message)
+	return err
+}
+
+// mailer is the Mailer used to send all email. It defaults to a LogMailer writing to MAIL_LOG_FILE.
+var mailer Mailer = &LogMailer{Path: os.Getenv("MAIL_LOG_FILE")}
+
+// SetMailer replaces the Mailer used to send email, e.g. with an SMTP or provider backed implementation.
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// SendMail sends an email through the configured Mailer.
+func SendMail(to, subject, body string) error {
+	return mailer.Send(to, subject, body)
+}
+
+// emailCooldown is how long after sending a link by email another request for the same account is
+// dropped, so repeated requests can't flood the inbox. 0 disables the cooldown.
+var emailCooldown = envDuration("EMAIL_COOLDOWN", time.Minute)
+
+// sentRecently reports whether a pending link matching filter, e.g. the user's, was created within
+// the email cooldown, in which case no new one is sent.
+func sentRecently(ctx context.Context, collection *mongo.Collection, filter bson.M) (bool, error) {
+	if emailCooldown <= 0 {
+		return false, nil
+	}
+	filter["created_at"] = bson.M{"$gt": time.Now().Add(-emailCooldown)}
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
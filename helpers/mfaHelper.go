@@ -0,0 +1,217 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"image/png"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TOTP parameters (RFC 6238): 6 digit SHA-1 codes on a 30 second step, which is what
+// authenticator apps support universally.
+const (
+	totpPeriod         = 30
+	totpDigits         = otp.DigitsSix
+	totpAlgorithm      = otp.AlgorithmSHA1
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 16
+)
+
+var (
+	// totpIssuer is the account issuer shown in authenticator apps.
+	totpIssuer = envString("TOTP_ISSUER", jwtIssuer)
+	// mfaPendingTTL is how long a user has to enter their second factor after the password step.
+	mfaPendingTTL = envDuration("MFA_PENDING_TTL", 5*time.Minute)
+	// mfaMaxAttempts is how many wrong codes one MFA pending token tolerates before it is burned.
+	mfaMaxAttempts = envInt("MFA_MAX_ATTEMPTS", 5)
+)
+
+// TOTPEnrollment is a freshly generated TOTP secret, ready to be shown to the user.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+	QRCode []byte // PNG image of the otpauth:// URI
+}
+
+// GenerateTOTPEnrollment creates a new TOTP secret for the account, with its otpauth:// URI
+// and a QR code PNG for authenticator apps to scan.
+func GenerateTOTPEnrollment(accountName string) (TOTPEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: accountName,
+		Period:      totpPeriod,
+		Digits:      totpDigits,
+		Algorithm:   totpAlgorithm,
+	})
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	image, err := key.Image(256, 256)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	var qrCode bytes.Buffer
+	if err := png.Encode(&qrCode, image); err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	return TOTPEnrollment{Secret: key.Secret(), URI: key.URL(), QRCode: qrCode.Bytes()}, nil
+}
+
+// SealTOTPSecret encrypts a user's TOTP secret for storage with the key encryption key. The user ID
+// is bound in, so a sealed secret only opens for the user it was stored for.
+func SealTOTPSecret(userID, secret string) (string, error) {
+	return encryptKeyMaterial(totpSecretAAD(userID), secret)
+}
+
+// OpenTOTPSecret decrypts a TOTP secret sealed by SealTOTPSecret. Secrets stored before sealing
+// was introduced are returned as is; sealStoredTOTPSecrets seals them at startup.
+func OpenTOTPSecret(userID, stored string) (string, error) {
+	return decryptKeyMaterial(totpSecretAAD(userID), stored)
+}
+
+// totpSecretAAD is the additional data TOTP secrets are sealed with, which keeps them apart from
+// the signing keys sealed under the same KEK.
+func totpSecretAAD(userID string) string {
+	return "totp:" + userID
+}
+
+// ValidateTOTPCode checks a code against the secret, allowing one step of clock drift either way.
+// Codes from a time step at or before lastStep are rejected so a code can't be replayed.
+// It returns the time step the code belongs to, which the caller must store as the new lastStep.
+func ValidateTOTPCode(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	now := time.Now()
+	currentStep := now.Unix() / totpPeriod
+
+	for _, step := range []int64{currentStep - 1, currentStep, currentStep + 1} {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    totpDigits,
+			Algorithm: totpAlgorithm,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes creates a set of one-time recovery codes. It returns the codes to show
+// the user once, and the hashes to store in their place.
+func GenerateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		// 10 random bytes give 16 base32 characters (80 bits).
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:recoveryCodeLength]
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the stored form of a recovery code. Case and dashes are ignored,
+// so the code can be typed either way.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return HashToken(normalized)
+}
+
+// HasSecondFactor reports whether the user has TOTP enabled or a passkey registered, so their
+// logins take a second step.
+func HasSecondFactor(user models.User) (bool, error) {
+	if user.Mfa_enabled {
+		return true, nil
+	}
+	return HasWebAuthnCredentials(user.User_id)
+}
+
+// GenerateMFAPendingToken issues the short-lived token a user receives after the password step
+// of a login that still needs a second factor. It can only be exchanged at the MFA login endpoint.
+func GenerateMFAPendingToken(uid string) (string, error) {
+	now := time.Now().Local()
+	claims := &SignedDetails{
+		Uid:        uid,
+		Token_type: TokenTypeMFAPending,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwtMFAAudience,
+			Subject:   uid,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			Id:        primitive.NewObjectID().Hex(),
+			ExpiresAt: now.Add(mfaPendingTTL).Unix(),
+		},
+	}
+	return signToken(claims)
+}
+
+// ValidateMFAPendingToken validates a token and rejects anything that isn't an MFA pending token.
+func ValidateMFAPendingToken(signedToken string) (claims *SignedDetails, msg string) {
+	return validateTokenOfType(signedToken, TokenTypeMFAPending)
+}
+
+// RecordMFAFailure counts a wrong code entered with an MFA pending token. Once the token reached
+// MFA_MAX_ATTEMPTS wrong codes it is burned, so a stolen password can't be used to go on guessing
+// codes; the user has to log in with the password again. It reports whether the token was burned.
+func RecordMFAFailure(claims *SignedDetails) (bool, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	var attempt models.LoginAttempt
+	err := loginAttemptCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"key": "mfa:" + claims.Id},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "failures", Value: 1}}},
+			{Key: "$set", Value: bson.D{{Key: "last_failure_at", Value: time.Now()}}},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "expires_at", Value: expiresAt},
+			}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return false, err
+	}
+	if attempt.Failures < mfaMaxAttempts {
+		return false, nil
+	}
+
+	burned, err := ConsumeToken(claims.Id, claims.Uid, expiresAt)
+	if burned {
+		LogSecurityEvent("mfa_token_burned", map[string]interface{}{
+			"user_id":  claims.Uid,
+			"token_id": claims.Id,
+			"failures": attempt.Failures,
+		})
+	}
+	return true, err
+}
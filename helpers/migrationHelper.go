@@ -0,0 +1,124 @@
+package helpers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RunMigrations brings existing documents up to date with the current schema.
+// Every migration is idempotent, so this is safe to call on every start.
+func RunMigrations() error {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if err := removeStoredTokens(ctx); err != nil {
+		return err
+	}
+	if err := grandfatherEmailVerification(ctx); err != nil {
+		return err
+	}
+	if err := backfillSessionExpiry(ctx); err != nil {
+		return err
+	}
+	return sealStoredTOTPSecrets(ctx)
+}
+
+// removeStoredTokens strips the plaintext access and refresh tokens older versions stored on
+// user documents. Sessions only keep a fingerprint of the refresh token now.
+func removeStoredTokens(ctx context.Context) error {
+	result, err := userCollection.UpdateMany(
+		ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"token": bson.M{"$exists": true}},
+			bson.M{"refresh_token": bson.M{"$exists": true}},
+		}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "token", Value: ""}, {Key: "refresh_token", Value: ""}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("removed stored tokens from %d user documents", result.ModifiedCount)
+	}
+	return nil
+}
+
+// grandfatherEmailVerification marks accounts created before email verification existed as
+// verified, so turning the policy on doesn't lock existing users out.
+func grandfatherEmailVerification(ctx context.Context) error {
+	_, err := userCollection.UpdateMany(
+		ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "email_verified", Value: true}}}},
+	)
+	return err
+}
+
+// backfillSessionExpiry sets the refresh token expiry on sessions started before it was stored.
+// Their current refresh token was issued at the last rotation, so it expires one refresh token
+// lifetime after updated_at; the TTL index then removes sessions that are long dead.
+func backfillSessionExpiry(ctx context.Context) error {
+	result, err := sessionCollection.UpdateMany(
+		ctx,
+		bson.M{"refresh_expires_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "refresh_expires_at", Value: bson.D{
+			{Key: "$add", Value: bson.A{"$updated_at", refreshTokenLifetime.Milliseconds()}},
+		}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("backfilled the refresh token expiry of %d sessions", result.ModifiedCount)
+	}
+	return nil
+}
+
+// sealStoredTOTPSecrets encrypts the TOTP secrets older versions stored in plaintext.
+func sealStoredTOTPSecrets(ctx context.Context) error {
+	plaintext := bson.M{"$type": "string", "$not": primitive.Regex{Pattern: "^" + encryptedKeyPrefix}}
+	cursor, err := userCollection.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"totp_secret": plaintext},
+		bson.M{"totp_pending_secret": plaintext},
+	}})
+	if err != nil {
+		return err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		for field, secret := range map[string]*string{"totp_secret": user.Totp_secret, "totp_pending_secret": user.Totp_pending_secret} {
+			if secret == nil || strings.HasPrefix(*secret, encryptedKeyPrefix) {
+				continue
+			}
+			sealed, err := SealTOTPSecret(user.User_id, *secret)
+			if err != nil {
+				return err
+			}
+			// Only replace the secret if it hasn't changed in the meantime.
+			_, err = userCollection.UpdateOne(
+				ctx,
+				bson.M{"user_id": user.User_id, field: *secret},
+				bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: sealed}}}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(users) > 0 {
+		log.Printf("sealed the TOTP secrets of %d users", len(users))
+	}
+	return nil
+}
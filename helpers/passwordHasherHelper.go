@@ -0,0 +1,213 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownPasswordHash is returned for a stored hash no PasswordHasher recognizes.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into a self-describing string that records the algorithm and
+// parameters used, so hashes made with older settings can still be verified and upgraded.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches an encoded hash this hasher recognizes.
+	Verify(password, encoded string) (bool, error)
+	// Recognizes reports whether the encoded hash was made with this hasher's algorithm.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether a recognized hash was made with other parameters than the current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher hashes passwords with argon2id (RFC 9106), encoded in the PHC string format:
+// $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<hash>.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// argon2idParams are the parameters read back from an encoded argon2id hash.
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory || params.iterations != h.Iterations || params.parallelism != h.Parallelism ||
+		uint32(len(params.salt)) != h.SaltLength || uint32(len(params.key)) != h.KeyLength
+}
+
+// parseArgon2id decodes an argon2id hash in the PHC string format.
+func parseArgon2id(encoded string) (argon2idParams, error) {
+	var params argon2idParams
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, ErrUnknownPasswordHash
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, ErrUnknownPasswordHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return params, ErrUnknownPasswordHash
+	}
+	return params, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt, in its own modular crypt format ($2a$<cost>$...),
+// which the PHC format adopts as is for bcrypt. Passwords are truncated at 72 bytes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hashed), err
+}
+
+func (h BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// The hasher new passwords are hashed with, chosen with PASSWORD_HASH_ALGORITHM ("argon2id", the
+// default, or "bcrypt"). The other one is kept to verify hashes made before the algorithm changed.
+var (
+	argon2idHasher = Argon2idHasher{
+		Memory:      uint32(envInt("ARGON2_MEMORY_KIB", 64*1024)),
+		Iterations:  uint32(envInt("ARGON2_ITERATIONS", 3)),
+		Parallelism: uint8(envInt("ARGON2_PARALLELISM", 2)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcryptHasher          = BcryptHasher{Cost: envInt("BCRYPT_COST", 12)}
+	currentPasswordHasher = mustPasswordHasher()
+	passwordHashers       = []PasswordHasher{argon2idHasher, bcryptHasher}
+)
+
+// mustPasswordHasher reads PASSWORD_HASH_ALGORITHM and checks the parameters are usable.
+func mustPasswordHasher() PasswordHasher {
+	switch algorithm := envString("PASSWORD_HASH_ALGORITHM", "argon2id"); algorithm {
+	case "argon2id":
+		if argon2idHasher.Memory < 8*uint32(argon2idHasher.Parallelism) || argon2idHasher.Iterations < 1 || argon2idHasher.Parallelism < 1 {
+			log.Fatal("invalid argon2id parameters")
+		}
+		return argon2idHasher
+	case "bcrypt":
+		if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+			log.Fatalf("invalid BCRYPT_COST %d", bcryptHasher.Cost)
+		}
+		return bcryptHasher
+	default:
+		log.Fatalf("invalid PASSWORD_HASH_ALGORITHM %q", algorithm)
+		return nil
+	}
+}
+
+// passwordHashSlots caps how many passwords are hashed at once at PASSWORD_HASH_CONCURRENCY, by
+// default the number of CPUs. Every argon2id hash holds ARGON2_MEMORY_KIB of memory while it runs,
+// so without a cap a burst of logins could exhaust the memory; hashes over the cap wait their turn.
+var passwordHashSlots = make(chan struct{}, mustPasswordHashConcurrency())
+
+// mustPasswordHashConcurrency reads PASSWORD_HASH_CONCURRENCY, failing fast if it isn't positive.
+func mustPasswordHashConcurrency() int {
+	concurrency := envInt("PASSWORD_HASH_CONCURRENCY", runtime.NumCPU())
+	if concurrency < 1 {
+		log.Fatalf("invalid PASSWORD_HASH_CONCURRENCY %d", concurrency)
+	}
+	return concurrency
+}
+
+// HashPassword hashes a password with the configured hasher.
+func HashPassword(password string) (string, error) {
+	passwordHashSlots <- struct{}{}
+	defer func() { <-passwordHashSlots }()
+
+	return currentPasswordHasher.Hash(password)
+}
+
+// VerifyPassword checks a password against a stored hash made by any of the supported hashers.
+func VerifyPassword(password, encoded string) (bool, error) {
+	for _, hasher := range passwordHashers {
+		if hasher.Recognizes(encoded) {
+			passwordHashSlots <- struct{}{}
+			defer func() { <-passwordHashSlots }()
+
+			return hasher.Verify(password, encoded)
+		}
+	}
+	return false, ErrUnknownPasswordHash
+}
+
+// PasswordNeedsRehash reports whether a stored hash was made with another algorithm or other
+// parameters than the configured ones, and should be replaced after the next successful login.
+func PasswordNeedsRehash(encoded string) bool {
+	return !currentPasswordHasher.Recognizes(encoded) || currentPasswordHasher.NeedsRehash(encoded)
+}
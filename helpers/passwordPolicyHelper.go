@@ -0,0 +1,190 @@
+package helpers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PasswordViolation is one password policy rule a candidate password breaks.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordCandidate is a password to check against the policy, with what is known about its owner.
+// Previous_hashes are the user's current and earlier password hashes, newest first.
+type PasswordCandidate struct {
+	Password        string
+	Email           string
+	First_name      string
+	Last_name       string
+	Previous_hashes []string
+}
+
+// passwordRule checks one aspect of a candidate password, returning a message if it is violated.
+type passwordRule struct {
+	name  string
+	check func(candidate PasswordCandidate) (string, error)
+}
+
+// The password policy. PASSWORD_MIN_CHARACTER_CLASSES is how many of lowercase, uppercase, digits
+// and symbols must appear; PASSWORD_HISTORY_SIZE is how many of the latest passwords, the current
+// one included, can't be reused.
+// BREACHED_PASSWORDS_DIR points to an offline copy of the Have I Been Pwned range files: one
+// <first 5 hex chars of the SHA-1>.txt file per prefix, with SUFFIX:COUNT lines, so only the prefix
+// of a password's hash is ever used to look it up. The breach check is skipped when it is unset.
+var (
+	passwordMinLength         = envInt("PASSWORD_MIN_LENGTH", 8)
+	passwordMinClasses        = envInt("PASSWORD_MIN_CHARACTER_CLASSES", 0)
+	passwordHistorySize       = envInt("PASSWORD_HISTORY_SIZE", 5)
+	breachedPasswordsDir      = envString("BREACHED_PASSWORDS_DIR", "")
+	breachedPasswordsMinCount = envInt("BREACHED_PASSWORDS_MIN_COUNT", 1)
+)
+
+var passwordRules = []passwordRule{
+	{name: "min_length", check: checkPasswordLength},
+	{name: "character_classes", check: checkPasswordClasses},
+	{name: "personal_info", check: checkPasswordPersonalInfo},
+	{name: "reused", check: checkPasswordReuse},
+	{name: "breached", check: checkPasswordBreached},
+}
+
+// CheckPasswordPolicy runs every rule of the policy and returns the ones the password violates.
+func CheckPasswordPolicy(candidate PasswordCandidate) ([]PasswordViolation, error) {
+	violations := []PasswordViolation{}
+	for _, rule := range passwordRules {
+		message, err := rule.check(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if message != "" {
+			violations = append(violations, PasswordViolation{Rule: rule.name, Message: message})
+		}
+	}
+	return violations, nil
+}
+
+func checkPasswordLength(candidate PasswordCandidate) (string, error) {
+	if len([]rune(candidate.Password)) < passwordMinLength {
+		return "password must be at least " + strconv.Itoa(passwordMinLength) + " characters long", nil
+	}
+	return "", nil
+}
+
+func checkPasswordClasses(candidate PasswordCandidate) (string, error) {
+	var lower, upper, digit, symbol bool
+	for _, r := range candidate.Password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < passwordMinClasses {
+		return "password must mix at least " + strconv.Itoa(passwordMinClasses) +
+			" of lowercase letters, uppercase letters, digits and symbols", nil
+	}
+	return "", nil
+}
+
+func checkPasswordPersonalInfo(candidate PasswordCandidate) (string, error) {
+	password := strings.ToLower(candidate.Password)
+
+	personal := []string{candidate.First_name, candidate.Last_name, candidate.Email}
+	if local, _, found := strings.Cut(candidate.Email, "@"); found {
+		personal = append(personal, local)
+	}
+	for _, value := range personal {
+		// Very short values like initials would reject too many passwords.
+		value = strings.ToLower(strings.TrimSpace(value))
+		if len([]rune(value)) >= 3 && strings.Contains(password, value) {
+			return "password must not contain your name or email address", nil
+		}
+	}
+	return "", nil
+}
+
+// checkPasswordReuse compares the password with the latest PASSWORD_HISTORY_SIZE hashes, the current
+// one first. Each comparison is a full password hash: with the argon2id defaults 64 MiB of memory
+// and a noticeable share of a CPU core, so a large history makes every password change that much slower.
+func checkPasswordReuse(candidate PasswordCandidate) (string, error) {
+	for i, hash := range candidate.Previous_hashes {
+		if i >= passwordHistorySize {
+			break
+		}
+		if reused, _ := VerifyPassword(candidate.Password, hash); reused {
+			return "password must not be one you have used recently", nil
+		}
+	}
+	return "", nil
+}
+
+func checkPasswordBreached(candidate PasswordCandidate) (string, error) {
+	if breachedPasswordsDir == "" {
+		return "", nil
+	}
+
+	sum := sha1.Sum([]byte(candidate.Password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(breachedPasswordsDir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		if n, err := strconv.Atoi(count); err == nil && n < breachedPasswordsMinCount {
+			return "", nil
+		}
+		return "password has appeared in a data breach, please choose another one", nil
+	}
+	return "", scanner.Err()
+}
+
+// PasswordHistoryUpdate returns the update operators that store a new password hash and push the
+// replaced one onto the user's password history, keeping only as many as the reuse check looks at:
+// together with the current hash, PASSWORD_HISTORY_SIZE.
+func PasswordHistoryUpdate(newHash, oldHash string, set bson.D) bson.D {
+	set = append(bson.D{{Key: "password", Value: newHash}}, set...)
+	kept := passwordHistorySize - 1
+	if kept < 0 {
+		kept = 0
+	}
+	return bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$push", Value: bson.D{{Key: "password_history", Value: bson.D{
+			{Key: "$each", Value: bson.A{oldHash}},
+			{Key: "$position", Value: 0},
+			{Key: "$slice", Value: kept},
+		}}}},
+	}
+}
@@ -0,0 +1,120 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidResetToken is returned for a reset token that is unknown, expired or already used.
+var ErrInvalidResetToken = errors.New("reset token is invalid, expired or has already been used")
+
+// Initializes `passwordResetCollection` to access the "password_reset" MongoDB collection.
+var passwordResetCollection *mongo.Collection = database.OpenCollection(database.Client, "password_reset")
+
+// passwordResetTTL is how long a reset link stays valid. PASSWORD_RESET_URL is the page the link
+// opens with the token appended as ?token=, normally the frontend form asking for the new password,
+// which then POSTs to /user/password/reset. It defaults to this service's GET /user/password/reset,
+// which only checks whether the token is still valid.
+var (
+	passwordResetTTL = envDuration("PASSWORD_RESET_TTL", time.Hour)
+	passwordResetURL = envString("PASSWORD_RESET_URL", appBaseURL+"/user/password/reset")
+)
+
+// SendPasswordResetEmail starts a password reset for the user: earlier pending resets are dropped,
+// a new single-use token is stored hashed, and a link carrying it is mailed to the user. Within the
+// email cooldown of the last link nothing is sent and that link stays valid.
+func SendPasswordResetEmail(userID, email string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if recent, err := sentRecently(ctx, passwordResetCollection, bson.M{"user_id": userID}); err != nil || recent {
+		return err
+	}
+
+	token, err := NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent link works.
+	if _, err := passwordResetCollection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err = passwordResetCollection.InsertOne(ctx, models.PasswordReset{
+		ID:         primitive.NewObjectID(),
+		Token_hash: HashToken(token),
+		User_id:    userID,
+		Expires_at: now.Add(passwordResetTTL),
+		Created_at: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := passwordResetURL + "?token=" + url.QueryEscape(token)
+	body := "A password reset was requested for your account. Use the link below to choose a new password.\n\n" + link +
+		"\n\nThe link expires in " + passwordResetTTL.String() + ". If you didn't request this, you can ignore this email."
+	return SendMail(email, "Reset your password", body)
+}
+
+// FindPasswordReset returns the ID of the user a pending reset token was issued to, without redeeming it.
+func FindPasswordReset(token string) (string, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var reset models.PasswordReset
+	err := passwordResetCollection.FindOne(
+		ctx,
+		bson.M{"token_hash": HashToken(token), "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return reset.User_id, nil
+}
+
+// ConsumePasswordReset redeems a reset token and returns the ID of the user it was issued to.
+// The pending reset is deleted in the same operation, so the token works only once.
+func ConsumePasswordReset(token string) (string, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var reset models.PasswordReset
+	err := passwordResetCollection.FindOneAndDelete(
+		ctx,
+		bson.M{"token_hash": HashToken(token), "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return reset.User_id, nil
+}
+
+// ensurePasswordResetIndexes indexes the token fingerprint and lets MongoDB drop expired resets.
+func ensurePasswordResetIndexes(ctx context.Context) error {
+	_, err := passwordResetCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -0,0 +1,223 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimitResult is the outcome of counting one request against a limit.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // Until the current window ends
+}
+
+// RateLimitStore counts requests per key with a sliding window: the count of the current fixed
+// window plus the previous window's count weighted by how much of it still overlaps the sliding one.
+// Every request is counted, including the ones that are refused.
+type RateLimitStore interface {
+	Hit(key string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
+// RateLimitRule is a limit of Limit requests per Window.
+type RateLimitRule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitRuleFromEnv reads a rule such as "100/1m" from RATE_LIMIT_<NAME>, falling back to def.
+func RateLimitRuleFromEnv(name string, def RateLimitRule) RateLimitRule {
+	variable := "RATE_LIMIT_" + strings.ToUpper(name)
+	value := envString(variable, "")
+	if value == "" {
+		return def
+	}
+	limit, window, found := strings.Cut(value, "/")
+	n, err := strconv.Atoi(limit)
+	if !found || err != nil || n < 1 {
+		log.Fatalf("invalid %s %q, expected <requests>/<window> like 100/1m", variable, value)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q, expected <requests>/<window> like 100/1m", variable, value)
+	}
+	return RateLimitRule{Limit: n, Window: d}
+}
+
+// NewRateLimitStore returns the store chosen with RATE_LIMIT_STORE: "memory" (the default) counts
+// in this process only, "mongo" shares the counters between all replicas through MongoDB.
+func NewRateLimitStore() RateLimitStore {
+	switch store := envString("RATE_LIMIT_STORE", "memory"); store {
+	case "memory":
+		return NewMemoryRateLimitStore()
+	case "mongo":
+		return NewMongoRateLimitStore()
+	default:
+		log.Fatalf("invalid RATE_LIMIT_STORE %q", store)
+		return nil
+	}
+}
+
+// slidingWindow computes the result of a hit from the counts of the previous and current fixed windows.
+func slidingWindow(limit int, window time.Duration, now, windowStart time.Time, previous, current int) RateLimitResult {
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(window)
+	count := int(math.Floor(float64(previous)*weight)) + current
+
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return RateLimitResult{
+		Allowed:   count <= limit,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     window - elapsed,
+	}
+}
+
+// memoryRateLimitCounter holds the counts of one key in the in-process store, along with the
+// window of the rule it is counted for, since rules with different windows share the store.
+type memoryRateLimitCounter struct {
+	window      time.Duration
+	windowStart time.Time
+	previous    int
+	current     int
+}
+
+// MemoryRateLimitStore is a RateLimitStore that keeps its counters in this process.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryRateLimitCounter
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty in-process store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: map[string]*memoryRateLimitCounter{}, lastSweep: time.Now()}
+}
+
+// Hit counts a request for the key.
+func (s *MemoryRateLimitStore) Hit(key string, limit int, window time.Duration) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	windowStart := now.Truncate(window)
+	s.sweep(now)
+
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &memoryRateLimitCounter{window: window, windowStart: windowStart}
+		s.counters[key] = counter
+	}
+	// Move the counts forward when a new window has started.
+	switch {
+	case counter.windowStart.Equal(windowStart):
+	case counter.windowStart.Add(window).Equal(windowStart):
+		counter.previous, counter.current = counter.current, 0
+		counter.windowStart = windowStart
+	default:
+		counter.previous, counter.current = 0, 0
+		counter.windowStart = windowStart
+	}
+	counter.current++
+
+	return slidingWindow(limit, window, now, windowStart, counter.previous, counter.current), nil
+}
+
+// memoryRateLimitSweepInterval is how often the in-process store looks for stale counters.
+const memoryRateLimitSweepInterval = time.Minute
+
+// sweep drops counters that haven't been hit for two of their own windows, at most once a minute.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryRateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, counter := range s.counters {
+		if now.Sub(counter.windowStart) > 2*counter.window {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// MongoRateLimitStore is a RateLimitStore that keeps one document per key and fixed window in the
+// "rate_limit" collection, so all replicas share the counters. Old windows are removed by a TTL index.
+type MongoRateLimitStore struct {
+	collection *mongo.Collection
+}
+
+// Initializes `rateLimitCollection` to access the "rate_limit" MongoDB collection.
+var rateLimitCollection *mongo.Collection = database.OpenCollection(database.Client, "rate_limit")
+
+// NewMongoRateLimitStore returns a store backed by the "rate_limit" collection.
+func NewMongoRateLimitStore() *MongoRateLimitStore {
+	return &MongoRateLimitStore{collection: rateLimitCollection}
+}
+
+// Hit counts a request for the key.
+func (s *MongoRateLimitStore) Hit(key string, limit int, window time.Duration) (RateLimitResult, error) {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	windowStart := now.Truncate(window)
+	windowKey := func(start time.Time) string {
+		return fmt.Sprintf("%s@%d", key, start.Unix())
+	}
+
+	// Count the request in the current window.
+	var current struct {
+		Count int `bson:"count"`
+	}
+	err := s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"key": windowKey(windowStart)},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "count", Value: 1}}},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "expires_at", Value: windowStart.Add(2 * window)},
+			}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&current)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	// Read the previous window, which may not exist.
+	var previous struct {
+		Count int `bson:"count"`
+	}
+	err = s.collection.FindOne(ctx, bson.M{"key": windowKey(windowStart.Add(-window))}).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return RateLimitResult{}, err
+	}
+
+	return slidingWindow(limit, window, now, windowStart, previous.Count, current.Count), nil
+}
+
+// ensureRateLimitIndexes makes the window keys unique and lets MongoDB drop old windows.
+func ensureRateLimitIndexes(ctx context.Context) error {
+	_, err := rateLimitCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
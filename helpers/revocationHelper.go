@@ -0,0 +1,100 @@
+package helpers
+
+import (
+	"context"
+	"time"
+
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Initializes `revokedTokenCollection` to access the "revoked_token" MongoDB collection.
+var revokedTokenCollection *mongo.Collection = database.OpenCollection(database.Client, "revoked_token")
+
+// RevokeToken adds a token ID to the denylist until the token would have expired anyway.
+// Revoking an already revoked token is a no-op.
+func RevokeToken(jti, userID string, expiresAt time.Time) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	// Nothing to do once the token has expired.
+	if jti == "" || time.Now().After(expiresAt) {
+		return nil
+	}
+
+	revokedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	upsert := true
+	_, err := revokedTokenCollection.UpdateOne(
+		ctx,
+		bson.M{"jti": jti},
+		bson.D{{Key: "$setOnInsert", Value: bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "user_id", Value: userID},
+			{Key: "expires_at", Value: expiresAt},
+			{Key: "revoked_at", Value: revokedAt},
+		}}},
+		&options.UpdateOptions{Upsert: &upsert},
+	)
+	return err
+}
+
+// ConsumeToken marks a single-use token as used by adding its ID to the denylist. It returns
+// false if the token was already consumed; the unique jti index makes this safe under concurrency.
+func ConsumeToken(jti, userID string, expiresAt time.Time) (bool, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	revokedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := revokedTokenCollection.InsertOne(ctx, models.RevokedToken{
+		ID:         primitive.NewObjectID(),
+		Jti:        jti,
+		User_id:    userID,
+		Expires_at: expiresAt,
+		Revoked_at: revokedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IsTokenRevoked reports whether the token ID is on the denylist.
+func IsTokenRevoked(jti string) (bool, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	count, err := revokedTokenCollection.CountDocuments(ctx, bson.M{"jti": jti}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// IsAccessTokenRevoked reports whether an access token was revoked, either on its own (its jti is
+// on the denylist) or together with the session it was derived from.
+func IsAccessTokenRevoked(claims *SignedDetails) (bool, error) {
+	revoked, err := IsTokenRevoked(claims.Id)
+	if err != nil || revoked || claims.Session_id == "" {
+		return revoked, err
+	}
+
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	count, err := sessionCollection.CountDocuments(ctx, bson.M{"session_id": claims.Session_id, "revoked": true}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// ensureRevocationIndexes makes jti unique and lets MongoDB drop entries once the token has expired.
+func ensureRevocationIndexes(ctx context.Context) error {
+	_, err := revokedTokenCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "jti", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
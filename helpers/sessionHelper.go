@@ -0,0 +1,309 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSessionNotFound is returned when a session does not exist or belongs to another user.
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionLastSeenInterval limits how often a session's last-seen time is written.
+const sessionLastSeenInterval = time.Minute
+
+// ErrRefreshTokenReused is returned when an already-rotated refresh token is presented again.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used, session revoked")
+
+// Initializes `sessionCollection` to access the "session" MongoDB collection.
+var sessionCollection *mongo.Collection = database.OpenCollection(database.Client, "session")
+
+// NewSessionID returns a new identifier for a login session (refresh token family).
+func NewSessionID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// unverifiedClaims reads the claims of a token this service just signed, without checking the signature again.
+func unverifiedClaims(signedToken string) (*SignedDetails, error) {
+	token, _, err := new(jwt.Parser).ParseUnverified(signedToken, &SignedDetails{})
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims.(*SignedDetails), nil
+}
+
+// StartSession records a new login session for the user, with the given token pair as the first
+// member of its refresh token family. The device is described by the request: the optional
+// `X-Device-Name` header, the User-Agent and the client IP.
+func StartSession(c *gin.Context, sessionID, userID, signedToken, signedRefreshToken string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	accessClaims, err := unverifiedClaims(signedToken)
+	if err != nil {
+		return err
+	}
+	refreshClaims, err := unverifiedClaims(signedRefreshToken)
+	if err != nil {
+		return err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	session := models.Session{
+		ID:                     primitive.NewObjectID(),
+		Session_id:             sessionID,
+		User_id:                userID,
+		Device_name:            c.GetHeader("X-Device-Name"),
+		User_agent:             c.Request.UserAgent(),
+		Ip_address:             c.ClientIP(),
+		Refresh_token_id:       refreshClaims.Id,
+		Refresh_token_hash:     HashToken(signedRefreshToken),
+		Used_refresh_token_ids: []string{},
+		Access_token_id:        accessClaims.Id,
+		Access_expires_at:      time.Unix(accessClaims.ExpiresAt, 0),
+		Refresh_expires_at:     time.Unix(refreshClaims.ExpiresAt, 0),
+		Created_at:             now,
+		Last_seen_at:           now,
+		Updated_at:             now,
+	}
+
+	_, err = sessionCollection.InsertOne(ctx, session)
+	return err
+}
+
+// RotateSession moves the session from the presented (already validated) refresh token to the newly
+// signed pair. The presented token must be the current member of the family; if it is a member that
+// was already rotated away, the whole family is revoked and ErrRefreshTokenReused is returned.
+func RotateSession(sessionID, presentedRefreshToken, signedToken, signedRefreshToken string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	presentedClaims, err := unverifiedClaims(presentedRefreshToken)
+	if err != nil {
+		return err
+	}
+	presentedTokenID := presentedClaims.Id
+
+	accessClaims, err := unverifiedClaims(signedToken)
+	if err != nil {
+		return err
+	}
+	refreshClaims, err := unverifiedClaims(signedRefreshToken)
+	if err != nil {
+		return err
+	}
+
+	// Only match the session if it is still live and the presented token is its current member.
+	// Sessions started before fingerprints were stored have no hash and match on the token ID alone.
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{
+		"session_id":       sessionID,
+		"refresh_token_id": presentedTokenID,
+		"revoked":          false,
+		"$or": bson.A{
+			bson.M{"refresh_token_hash": HashToken(presentedRefreshToken)},
+			bson.M{"refresh_token_hash": bson.M{"$exists": false}},
+		},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "refresh_token_id", Value: refreshClaims.Id},
+			{Key: "refresh_token_hash", Value: HashToken(signedRefreshToken)},
+			{Key: "access_token_id", Value: accessClaims.Id},
+			{Key: "access_expires_at", Value: time.Unix(accessClaims.ExpiresAt, 0)},
+			{Key: "refresh_expires_at", Value: time.Unix(refreshClaims.ExpiresAt, 0)},
+			{Key: "last_seen_at", Value: updatedAt},
+			{Key: "updated_at", Value: updatedAt},
+		}},
+		{Key: "$push", Value: bson.D{{Key: "used_refresh_token_ids", Value: presentedTokenID}}},
+	}
+
+	result, err := sessionCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	// The rotation did not match: figure out whether this is a replay of an older family member.
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, bson.M{"session_id": sessionID}).Decode(&session); err != nil {
+		return ErrInvalidRefreshToken
+	}
+	if session.Revoked {
+		return ErrInvalidRefreshToken
+	}
+	for _, usedID := range session.Used_refresh_token_ids {
+		if usedID == presentedTokenID {
+			if err := RevokeSession(sessionID); err != nil {
+				return err
+			}
+			LogSecurityEvent("refresh_token_reuse", map[string]interface{}{
+				"session_id": sessionID,
+				"user_id":    session.User_id,
+				"token_id":   presentedTokenID,
+			})
+			return ErrRefreshTokenReused
+		}
+	}
+	return ErrInvalidRefreshToken
+}
+
+// TouchSession records that the session was just used. To keep this cheap on every request,
+// the last-seen time is only written when it is more than a minute old.
+func TouchSession(sessionID string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := sessionCollection.UpdateOne(
+		ctx,
+		bson.M{"session_id": sessionID, "last_seen_at": bson.M{"$lt": now.Add(-sessionLastSeenInterval)}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "last_seen_at", Value: now}}}},
+	)
+	return err
+}
+
+// ListSessions returns the user's live sessions, most recently used first. Sessions whose refresh
+// token has expired are left out even before the TTL index gets to remove them.
+func ListSessions(userID string) ([]models.Session, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}})
+	filter := bson.M{"user_id": userID, "revoked": false, "refresh_expires_at": bson.M{"$gt": time.Now()}}
+	cursor, err := sessionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	err = cursor.All(ctx, &sessions)
+	return sessions, err
+}
+
+// RevokeUserSession ends one of the user's sessions. It returns ErrSessionNotFound if the
+// session doesn't exist, is already revoked, or belongs to someone else.
+func RevokeUserSession(userID, sessionID string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	count, err := sessionCollection.CountDocuments(ctx, bson.M{"session_id": sessionID, "user_id": userID, "revoked": false})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSessionNotFound
+	}
+	return RevokeSession(sessionID)
+}
+
+// IsRefreshTokenCurrent reports whether the refresh token ID is the current member of a live session.
+func IsRefreshTokenCurrent(sessionID, tokenID string) (bool, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	count, err := sessionCollection.CountDocuments(ctx, bson.M{
+		"session_id":       sessionID,
+		"refresh_token_id": tokenID,
+		"revoked":          false,
+	})
+	return count > 0, err
+}
+
+// RevokeSession marks a refresh token family as revoked so none of its tokens can be exchanged again,
+// and denylists the access token that was issued with its current refresh token.
+func RevokeSession(sessionID string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	revokedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	// Flip the session to revoked and read it back to find its outstanding access token.
+	var session models.Session
+	err := sessionCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"session_id": sessionID},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "revoked", Value: true},
+			{Key: "revoked_at", Value: revokedAt},
+			{Key: "updated_at", Value: revokedAt},
+		}}},
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if session.Access_token_id == "" {
+		return nil
+	}
+	return RevokeToken(session.Access_token_id, session.User_id, session.Access_expires_at)
+}
+
+// RevokeAllSessions revokes every live session of the user, e.g. when an admin disables the account.
+func RevokeAllSessions(userID string) error {
+	return RevokeOtherSessions(userID, "")
+}
+
+// RevokeOtherSessions revokes every live session of the user except keepSessionID,
+// e.g. to sign out other devices after a password change.
+func RevokeOtherSessions(userID, keepSessionID string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "revoked": false}
+	if keepSessionID != "" {
+		filter["session_id"] = bson.M{"$ne": keepSessionID}
+	}
+	cursor, err := sessionCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := RevokeSession(session.Session_id); err != nil {
+			return err
+		}
+	}
+
+	LogSecurityEvent("sessions_revoked", map[string]interface{}{
+		"user_id":  userID,
+		"sessions": len(sessions),
+	})
+	return nil
+}
+
+// ensureSessionIndexes indexes sessions by ID and user, and lets MongoDB drop sessions once
+// their refresh token has expired.
+func ensureSessionIndexes(ctx context.Context) error {
+	_, err := sessionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "session_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "refresh_expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -1,18 +1,19 @@
 package helpers
 
 import (
-	"context"
-	"fmt"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"log"
 	"os"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"                  // Importing the JWT package for creating and validating tokens.
 	"github.com/rkmangalp/golang-JWT-project/database" // Importing the custom database package for MongoDB operations.
-	"go.mongodb.org/mongo-driver/bson"                 // BSON package for MongoDB to encode/decode BSON data.
 	"go.mongodb.org/mongo-driver/bson/primitive"       // BSON primitives, like ObjectID, used in MongoDB.
 	"go.mongodb.org/mongo-driver/mongo"                // MongoDB driver package for Go.
-	"go.mongodb.org/mongo-driver/mongo/options"        // Options package for MongoDB operations like UpdateOptions.
 )
 
 type SignedDetails struct {
@@ -21,48 +22,106 @@ type SignedDetails struct {
 	Last_name          string
 	Uid                string
 	User_type          string
-	jwt.StandardClaims // Embedding standard JWT claims like ExpiresAt.
+	Session_id         string // Login session (refresh token family) the token belongs to.
+	Token_type         string // TokenTypeAccess, TokenTypeRefresh, ..., so one can't be used as another.
+	Email_verified     bool   // Whether the user had verified their email address when the token was issued.
+	Mfa_enabled        bool   // Whether the user had a second factor when the token was issued.
+	jwt.StandardClaims        // Embedding standard JWT claims like ExpiresAt.
 }
 
 // Initializes `userCollection` to access the "user" MongoDB collection.
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
+// ErrInvalidRefreshToken is returned when a presented refresh token is not the current member of its session.
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or has already been used")
+
 // Retrieves the `SECRET_KEY` from environment variables for JWT signing.
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
+// Token types carried in the Token_type claim.
+const (
+	TokenTypeAccess     = "access"
+	TokenTypeRefresh    = "refresh"
+	TokenTypeMFAPending = "mfa_pending"
+)
+
+// refreshTokenLifetime is how long a refresh token, and so a session it keeps alive, is valid.
+const refreshTokenLifetime = 168 * time.Hour
+
+// The registered claims every token is issued with and checked against.
+// Refresh tokens get their own audience, so services accepting our access tokens reject them
+// even if they don't look at Token_type. MFA pending tokens are only consumed by this service and
+// are addressed to it under an audience of their own, so they can't pass for any other token.
+// JWT_LEEWAY is the clock skew tolerated when checking exp, nbf and iat.
+var (
+	jwtIssuer          = envString("JWT_ISSUER", "golang-JWT-project")
+	jwtAudience        = envString("JWT_AUDIENCE", "golang-JWT-project")
+	jwtRefreshAudience = envString("JWT_REFRESH_AUDIENCE", jwtAudience+":refresh")
+	jwtMFAAudience     = jwtIssuer + ":mfa"
+	jwtLeeway          = envDuration("JWT_LEEWAY", 30*time.Second)
+)
+
 // GenerateAllTokens creates an access token and a refresh token for the user with given details.
 // Returns the signed access token, signed refresh token, and any error encountered during the process.
-func GenerateAllTokens(email, first_name, last_name, userType, uid string) (signedToken, signedRefreshToken string, err error) {
+// Both tokens carry the session ID so the refresh token can be tracked as part of its login's token family.
+func GenerateAllTokens(email, first_name, last_name, userType, uid, sessionID string, emailVerified, mfaEnabled bool) (signedToken, signedRefreshToken string, err error) {
+	now := time.Now().Local()
+
 	// Define the claims for the access token.
 	claims := &SignedDetails{
-		Email:      email,      // User's email address
-		First_name: first_name, // User's first name
-		Last_name:  last_name,  // User's last name
-		Uid:        uid,        // User's unique identifier
-		User_type:  userType,   // Type of user (e.g., admin, regular user)
+		Email:      email,           // User's email address
+		First_name: first_name,      // User's first name
+		Last_name:  last_name,       // User's last name
+		Uid:        uid,             // User's unique identifier
+		User_type:  userType,        // Type of user (e.g., admin, regular user)
+		Session_id: sessionID,       // Login session the token was issued for
+		Token_type: TokenTypeAccess, // Only accepted where an access token is expected
+		// Whether the user's email address was verified at login
+		Email_verified: emailVerified,
+		// Whether the user had a second factor at login; administrators need one to act as such
+		Mfa_enabled: mfaEnabled,
 		StandardClaims: jwt.StandardClaims{
+			Issuer:    jwtIssuer,   // Service that issued the token
+			Audience:  jwtAudience, // Services the token is meant for
+			Subject:   uid,         // User the token was issued to
+			IssuedAt:  now.Unix(),  // Time the token was issued
+			NotBefore: now.Unix(),  // Token is not valid before it was issued
+			// Unique ID so the access token can be revoked on its own
+			Id: primitive.NewObjectID().Hex(),
 			// Access token expiration time set to 24 hours from the current time
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			ExpiresAt: now.Add(time.Hour * time.Duration(24)).Unix(),
 		},
 	}
 
 	// Define the claims for the refresh token with a longer expiration time.
 	refreshClaims := &SignedDetails{
+		Uid:        uid,              // User's unique identifier
+		Session_id: sessionID,        // Token family the refresh token belongs to
+		Token_type: TokenTypeRefresh, // Only accepted by the refresh flow
+		// Carried over to the refreshed access token, so enabling MFA mid-session doesn't grant it
+		Mfa_enabled: mfaEnabled,
 		StandardClaims: jwt.StandardClaims{
+			Issuer:    jwtIssuer,          // Service that issued the token
+			Audience:  jwtRefreshAudience, // Only this service's refresh flow
+			Subject:   uid,                // User the token was issued to
+			IssuedAt:  now.Unix(),         // Time the token was issued
+			NotBefore: now.Unix(),         // Token is not valid before it was issued
+			// Unique ID so every member of the token family can be told apart
+			Id: primitive.NewObjectID().Hex(),
 			// Refresh token expiration time set to 7 days (168 hours) from the current time
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			ExpiresAt: now.Add(refreshTokenLifetime).Unix(),
 		},
 	}
 
-	// Generate the access token with the defined claims and sign it using the SECRET_KEY.
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	// Generate the access token with the defined claims and sign it with the configured key.
+	token, err := signToken(claims)
 	if err != nil {
 		log.Panic(err) // Log any error encountered during token signing
 		return         // Return empty strings and error if token signing fails
 	}
 
-	// Generate the refresh token with the defined claims and sign it using the SECRET_KEY.
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	// Generate the refresh token with the defined claims and sign it with the configured key.
+	refreshToken, err := signToken(refreshClaims)
 	if err != nil {
 		log.Panic(err) // Log any error encountered during token signing
 		return         // Return empty strings and error if token signing fails
@@ -72,16 +131,19 @@ func GenerateAllTokens(email, first_name, last_name, userType, uid string) (sign
 	return token, refreshToken, nil
 }
 
-// ValidateToken validates a JWT token and extracts its claims.
+// ValidateToken validates a JWT token of either type and extracts its claims.
+// Besides the signature it checks the registered claims: the issuer and the audience for the
+// token's type must match the configured ones, and exp, nbf and iat are compared allowing
+// JWT_LEEWAY of clock skew. Use ValidateAccessToken or ValidateRefreshToken where only one type is acceptable.
 // It returns the token claims and an error message if validation fails.
 func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	// Parse the token with claims and validate it using the SECRET_KEY.
-	token, err := jwt.ParseWithClaims(
+	// Parse the token with claims and validate it against the key named by its `kid` header.
+	// The time based claims are checked below instead, so the leeway can be applied.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(
 		signedToken,
-		&SignedDetails{}, // Claims structure to parse the token into
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil // Function to provide the key for validation
-		},
+		&SignedDetails{},   // Claims structure to parse the token into
+		verificationKeyFor, // Function to provide the key for validation
 	)
 
 	// If there's an error during parsing, return the error message.
@@ -93,59 +155,98 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	// Extract and type assert the claims from the parsed token.
 	claims, ok := token.Claims.(*SignedDetails)
 	if !ok {
-		msg = fmt.Sprintf("the token is invalid") // Invalid claims type
-		msg = err.Error()                         // Set error message
-		return
+		msg = "the token is invalid" // Invalid claims type
+		return nil, msg
 	}
 
-	// Check if the token has expired.
-	if claims.ExpiresAt < time.Now().Local().Unix() {
-		msg = fmt.Sprintf("token is expired") // Token is expired
-		msg = err.Error()                     // Set error message
-		return
+	// Check the registered claims against the audience of the token's type.
+	audience, ok := audienceFor(claims.Token_type)
+	if !ok {
+		return nil, "token has an unknown type"
+	}
+	if msg = validateRegisteredClaims(claims, audience); msg != "" {
+		return nil, msg
 	}
 
 	// Return the valid claims and an empty message if validation is successful.
 	return claims, msg
 }
 
-// UpdateAllTokens updates the JWT access token, refresh token, and the updated timestamp for a user in the MongoDB collection.
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userID string) {
-	// Create a context with a 100-second timeout for the database operation.
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	defer cancel() // Ensure context is canceled after the operation
-
-	// Initialize an update object to hold fields to be updated.
-	var updateObj primitive.D
-
-	// Add the new access token to the update object.
-	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
-	// Add the new refresh token to the update object.
-	updateObj = append(updateObj, bson.E{Key: "refresh_token", Value: signedRefreshToken})
-
-	// Add the current timestamp as the updated_at field.
-	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: updatedAt})
-
-	// Define upsert option to create a new document if it does not exist.
-	upsert := true
-	filter := bson.M{"user_id": userID} // Filter to find the user by userID
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	// Perform the update operation on the MongoDB collection.
-	_, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{
-			{Key: "$set", Value: updateObj}, // Update operation to set new values
-		},
-		&opt, // Update options with upsert enabled
-	)
+// audienceFor returns the audience tokens of the given type are issued for.
+func audienceFor(tokenType string) (string, bool) {
+	switch tokenType {
+	case TokenTypeAccess:
+		return jwtAudience, true
+	case TokenTypeRefresh:
+		return jwtRefreshAudience, true
+	case TokenTypeMFAPending:
+		return jwtMFAAudience, true
+	}
+	return "", false
+}
 
-	// Handle any errors encountered during the update operation.
-	if err != nil {
-		// Error handling logic should be added here
+// validateRegisteredClaims checks iss, aud, exp, nbf and iat, returning an error message if any fails.
+func validateRegisteredClaims(claims *SignedDetails, audience string) string {
+	now := time.Now().Local()
+	leeway := int64(jwtLeeway / time.Second)
+
+	// Check if the token has expired.
+	if claims.ExpiresAt == 0 || now.Unix() > claims.ExpiresAt+leeway {
+		return "token is expired"
+	}
+	// Check if the token is already valid.
+	if now.Unix()+leeway < claims.NotBefore {
+		return "token is not valid yet"
+	}
+	// Check that the token wasn't issued in the future.
+	if now.Unix()+leeway < claims.IssuedAt {
+		return "token used before issued"
+	}
+	// Check that the token was issued by us, for the expected audience.
+	if !claims.VerifyIssuer(jwtIssuer, true) {
+		return "token has an invalid issuer"
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return "token has an invalid audience"
+	}
+	return ""
+}
+
+// ValidateAccessToken validates a token and rejects anything that isn't an access token.
+func ValidateAccessToken(signedToken string) (claims *SignedDetails, msg string) {
+	return validateTokenOfType(signedToken, TokenTypeAccess)
+}
+
+// ValidateRefreshToken validates a token and rejects anything that isn't a refresh token.
+func ValidateRefreshToken(signedToken string) (claims *SignedDetails, msg string) {
+	return validateTokenOfType(signedToken, TokenTypeRefresh)
+}
+
+// validateTokenOfType validates a token and checks its Token_type claim.
+func validateTokenOfType(signedToken, tokenType string) (claims *SignedDetails, msg string) {
+	claims, msg = ValidateToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+	if claims.Token_type != tokenType {
+		return nil, "token is not a " + tokenType + " token"
+	}
+	return claims, msg
+}
+
+// HashToken returns the fingerprint stored in place of a token: the hex encoded SHA-256 of it.
+// Tokens are high-entropy, so an unsalted fast hash is enough to make the stored value useless to a reader.
+func HashToken(signedToken string) string {
+	sum := sha256.Sum256([]byte(signedToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewOpaqueToken returns a random, URL-safe 256-bit token for links sent by email.
+// Only its HashToken fingerprint should be stored.
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,526 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/rkmangalp/golang-JWT-project/database"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Purposes of a WebAuthn ceremony. A ceremony can only be finished for the purpose it was begun for.
+const (
+	WebAuthnPurposeRegistration = "registration"
+	WebAuthnPurposeLogin        = "login" // Passwordless login with a discoverable credential
+	WebAuthnPurposeMFA          = "mfa"   // Second factor after the password step
+)
+
+// ErrInvalidWebAuthnCeremony is returned for a ceremony that is unknown, expired, already finished
+// or was begun for another purpose or user.
+var ErrInvalidWebAuthnCeremony = errors.New("webauthn ceremony is invalid, expired or has already been used")
+
+// ErrWebAuthnVerificationFailed is returned when the authenticator's response doesn't verify.
+var ErrWebAuthnVerificationFailed = errors.New("webauthn verification failed")
+
+// ErrWebAuthnCredentialNotFound is returned when a credential does not exist or belongs to another user.
+var ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+
+// Initializes `webauthnCredentialCollection` to access the "webauthn_credential" MongoDB collection.
+var webauthnCredentialCollection *mongo.Collection = database.OpenCollection(database.Client, "webauthn_credential")
+
+// Initializes `webauthnCeremonyCollection` to access the "webauthn_ceremony" MongoDB collection.
+var webauthnCeremonyCollection *mongo.Collection = database.OpenCollection(database.Client, "webauthn_ceremony")
+
+// The relying party this service acts as. WEBAUTHN_RP_ID is the domain credentials are scoped to and
+// WEBAUTHN_RP_ORIGINS the comma separated origins the browser may run the ceremonies from; both
+// default to APP_BASE_URL. WEBAUTHN_CEREMONY_TTL is how long the client has to answer a challenge.
+var (
+	webauthnRPName      = envString("WEBAUTHN_RP_NAME", jwtIssuer)
+	webauthnRPOrigins   = envString("WEBAUTHN_RP_ORIGINS", appBaseURL)
+	webauthnRPID        = envString("WEBAUTHN_RP_ID", hostnameOf(appBaseURL))
+	webauthnCeremonyTTL = envDuration("WEBAUTHN_CEREMONY_TTL", 5*time.Minute)
+)
+
+var relyingParty = mustNewRelyingParty()
+
+// hostnameOf returns the host of a URL without its port.
+func hostnameOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// mustNewRelyingParty builds the WebAuthn relying party from the configuration, failing fast if it is invalid.
+func mustNewRelyingParty() *webauthn.WebAuthn {
+	var origins []string
+	for _, origin := range strings.Split(webauthnRPOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          webauthnRPID,
+		RPDisplayName: webauthnRPName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		log.Fatalf("invalid WebAuthn configuration: %v", err)
+	}
+	return rp
+}
+
+// webauthnUser adapts a user and their stored credentials to the webauthn.User interface.
+// The user handle is the User_id, so it never reveals the email address.
+type webauthnUser struct {
+	user        models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u webauthnUser) WebAuthnID() []byte {
+	return []byte(u.user.User_id)
+}
+
+func (u webauthnUser) WebAuthnName() string {
+	return models.StringValue(u.user.Email)
+}
+
+func (u webauthnUser) WebAuthnDisplayName() string {
+	return strings.TrimSpace(models.StringValue(u.user.First_name) + " " + models.StringValue(u.user.Last_name))
+}
+
+func (u webauthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		id, err := base64.RawURLEncoding.DecodeString(stored.Credential_id)
+		if err != nil {
+			continue
+		}
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, transport := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       stored.Public_key,
+			AttestationType: stored.Attestation_type,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserVerified:   stored.User_verified,
+				BackupEligible: stored.Backup_eligible,
+				BackupState:    stored.Backup_state,
+			},
+			Authenticator: webauthn.Authenticator{AAGUID: stored.Aaguid, SignCount: stored.Sign_count},
+		})
+	}
+	return credentials
+}
+
+// loadWebAuthnUser loads a user and their credentials.
+func loadWebAuthnUser(ctx context.Context, userID string) (webauthnUser, error) {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		return webauthnUser{}, err
+	}
+	credentials, err := findWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return webauthnUser{}, err
+	}
+	return webauthnUser{user: user, credentials: credentials}, nil
+}
+
+// findWebAuthnCredentials returns the user's registered credentials, oldest first.
+func findWebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := webauthnCredentialCollection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	credentials := []models.WebAuthnCredential{}
+	err = cursor.All(ctx, &credentials)
+	return credentials, err
+}
+
+// startCeremony stores the session data of a ceremony and returns the ID the client finishes it with.
+func startCeremony(ctx context.Context, purpose, userID string, session *webauthn.SessionData) (string, error) {
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	ceremonyID, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = webauthnCeremonyCollection.InsertOne(ctx, models.WebAuthnCeremony{
+		ID:           primitive.NewObjectID(),
+		Ceremony_id:  ceremonyID,
+		Purpose:      purpose,
+		User_id:      userID,
+		Session_data: string(sessionData),
+		Expires_at:   time.Now().Add(webauthnCeremonyTTL),
+	})
+	return ceremonyID, err
+}
+
+// consumeCeremony loads and deletes a ceremony in one operation, so a challenge can only be answered once.
+func consumeCeremony(ctx context.Context, ceremonyID, purpose string) (models.WebAuthnCeremony, webauthn.SessionData, error) {
+	var ceremony models.WebAuthnCeremony
+	var session webauthn.SessionData
+
+	err := webauthnCeremonyCollection.FindOneAndDelete(
+		ctx,
+		bson.M{"ceremony_id": ceremonyID, "purpose": purpose, "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&ceremony)
+	if err == mongo.ErrNoDocuments {
+		return ceremony, session, ErrInvalidWebAuthnCeremony
+	}
+	if err != nil {
+		return ceremony, session, err
+	}
+
+	if err := json.Unmarshal([]byte(ceremony.Session_data), &session); err != nil {
+		return ceremony, session, err
+	}
+	return ceremony, session, nil
+}
+
+// BeginWebAuthnRegistration starts registering a new passkey for the user. It returns the options
+// to pass to navigator.credentials.create() and the ceremony ID to finish the registration with.
+// Credentials the user already has are excluded, so the same authenticator isn't registered twice.
+func BeginWebAuthnRegistration(userID string) (*protocol.CredentialCreation, string, error) {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := relyingParty.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, "", err
+	}
+	ceremonyID, err := startCeremony(ctx, WebAuthnPurposeRegistration, userID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, ceremonyID, nil
+}
+
+// FinishWebAuthnRegistration verifies the authenticator's attestation response (the JSON encoded
+// PublicKeyCredential) for a registration ceremony begun by the same user and stores the new credential.
+func FinishWebAuthnRegistration(userID, ceremonyID, name string, response []byte) (models.WebAuthnCredential, error) {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	ceremony, session, err := consumeCeremony(ctx, ceremonyID, WebAuthnPurposeRegistration)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	if ceremony.User_id != userID {
+		return models.WebAuthnCredential{}, ErrInvalidWebAuthnCeremony
+	}
+
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return models.WebAuthnCredential{}, ErrWebAuthnVerificationFailed
+	}
+	credential, err := relyingParty.CreateCredential(user, session, parsed)
+	if err != nil {
+		return models.WebAuthnCredential{}, ErrWebAuthnVerificationFailed
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	createdAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	stored := models.WebAuthnCredential{
+		ID:               primitive.NewObjectID(),
+		Credential_id:    base64.RawURLEncoding.EncodeToString(credential.ID),
+		User_id:          userID,
+		Name:             name,
+		Public_key:       credential.PublicKey,
+		Attestation_type: credential.AttestationType,
+		Transports:       transports,
+		Aaguid:           credential.Authenticator.AAGUID,
+		Sign_count:       credential.Authenticator.SignCount,
+		User_verified:    credential.Flags.UserVerified,
+		Backup_eligible:  credential.Flags.BackupEligible,
+		Backup_state:     credential.Flags.BackupState,
+		Created_at:       createdAt,
+	}
+	if _, err := webauthnCredentialCollection.InsertOne(ctx, stored); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.WebAuthnCredential{}, ErrWebAuthnVerificationFailed
+		}
+		return models.WebAuthnCredential{}, err
+	}
+
+	LogSecurityEvent("webauthn_credential_registered", map[string]interface{}{
+		"user_id":       userID,
+		"credential_id": stored.Credential_id,
+	})
+
+	// Tell the user, so a passkey added by someone else doesn't go unnoticed. Failures are only logged.
+	body := "A passkey named \"" + stored.Name + "\" was added to your account on " + createdAt.Format(time.RFC1123) +
+		".\n\nIf this wasn't you, reset your password, which also removes all passkeys, and review your sessions."
+	if err := SendMail(models.StringValue(user.user.Email), "A passkey was added to your account", body); err != nil {
+		log.Println("error sending passkey notification email:", err)
+	}
+	return stored, nil
+}
+
+// BeginWebAuthnLogin starts a login ceremony and returns the options to pass to
+// navigator.credentials.get() with the ceremony ID to finish the login with.
+// With an empty userID it is a passwordless login: any discoverable credential may answer and user
+// verification is required, since the passkey is the only factor presented. Otherwise it is the second
+// factor for that user, and only their registered credentials are allowed.
+func BeginWebAuthnLogin(userID string) (*protocol.CredentialAssertion, string, error) {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if userID == "" {
+		assertion, session, err := relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			return nil, "", err
+		}
+		ceremonyID, err := startCeremony(ctx, WebAuthnPurposeLogin, "", session)
+		if err != nil {
+			return nil, "", err
+		}
+		return assertion, ceremonyID, nil
+	}
+
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(user.credentials) == 0 {
+		return nil, "", ErrWebAuthnCredentialNotFound
+	}
+	assertion, session, err := relyingParty.BeginLogin(user)
+	if err != nil {
+		return nil, "", err
+	}
+	ceremonyID, err := startCeremony(ctx, WebAuthnPurposeMFA, userID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, ceremonyID, nil
+}
+
+// FinishWebAuthnLogin verifies the authenticator's assertion response (the JSON encoded
+// PublicKeyCredential) for a login ceremony and returns the ID of the user it authenticates.
+// An empty userID finishes a passwordless login; otherwise the ceremony must have been begun as the
+// second factor of that user. The credential's signature counter is updated, and a counter that went
+// backwards, which suggests a cloned authenticator, fails the login.
+func FinishWebAuthnLogin(userID, ceremonyID string, response []byte) (string, error) {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	purpose := WebAuthnPurposeMFA
+	if userID == "" {
+		purpose = WebAuthnPurposeLogin
+	}
+	ceremony, session, err := consumeCeremony(ctx, ceremonyID, purpose)
+	if err != nil {
+		return "", err
+	}
+	if ceremony.User_id != userID {
+		return "", ErrInvalidWebAuthnCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return "", ErrWebAuthnVerificationFailed
+	}
+
+	// Verify the assertion against the credentials of the user it claims to be from.
+	var user webauthnUser
+	var credential *webauthn.Credential
+	if userID == "" {
+		credential, err = relyingParty.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			user, err = loadWebAuthnUser(ctx, string(userHandle))
+			return user, err
+		}, session, parsed)
+	} else {
+		user, err = loadWebAuthnUser(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		credential, err = relyingParty.ValidateLogin(user, session, parsed)
+	}
+	if err != nil {
+		return "", ErrWebAuthnVerificationFailed
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	if credential.Authenticator.CloneWarning {
+		LogSecurityEvent("webauthn_clone_warning", map[string]interface{}{
+			"user_id":       user.user.User_id,
+			"credential_id": credentialID,
+		})
+		return "", ErrWebAuthnVerificationFailed
+	}
+
+	// Record the new signature counter; matching on the old one makes concurrent replays of the same counter fail.
+	lastUsedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	var previousSignCount uint32
+	for _, stored := range user.credentials {
+		if stored.Credential_id == credentialID {
+			previousSignCount = stored.Sign_count
+		}
+	}
+	result, err := webauthnCredentialCollection.UpdateOne(
+		ctx,
+		bson.M{"credential_id": credentialID, "user_id": user.user.User_id, "sign_count": previousSignCount},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "sign_count", Value: credential.Authenticator.SignCount},
+			{Key: "backup_state", Value: credential.Flags.BackupState},
+			{Key: "last_used_at", Value: lastUsedAt},
+		}}},
+	)
+	if err != nil {
+		return "", err
+	}
+	if result.MatchedCount == 0 {
+		return "", ErrWebAuthnVerificationFailed
+	}
+
+	return user.user.User_id, nil
+}
+
+// HasWebAuthnCredentials reports whether the user has registered any passkey.
+func HasWebAuthnCredentials(userID string) (bool, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	count, err := webauthnCredentialCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+	return count > 0, err
+}
+
+// ListWebAuthnCredentials returns the user's registered passkeys, oldest first.
+func ListWebAuthnCredentials(userID string) ([]models.WebAuthnCredential, error) {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	return findWebAuthnCredentials(ctx, userID)
+}
+
+// DeleteWebAuthnCredential removes one of the user's passkeys. It returns ErrWebAuthnCredentialNotFound
+// if the credential doesn't exist or belongs to someone else.
+func DeleteWebAuthnCredential(userID, credentialID string) error {
+	// Create a context with a 100-second timeout for the database operation.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var removed models.WebAuthnCredential
+	err := webauthnCredentialCollection.FindOneAndDelete(ctx, bson.M{"credential_id": credentialID, "user_id": userID}).Decode(&removed)
+	if err == mongo.ErrNoDocuments {
+		return ErrWebAuthnCredentialNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	LogSecurityEvent("webauthn_credential_removed", map[string]interface{}{
+		"user_id":       userID,
+		"credential_id": credentialID,
+	})
+
+	// Tell the user, as on registration, so a passkey removed by someone else doesn't go unnoticed.
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		log.Println("error loading the user for the passkey notification email:", err)
+		return nil
+	}
+	body := "The passkey named \"" + removed.Name + "\" was removed from your account on " + time.Now().Format(time.RFC1123) +
+		".\n\nIf this wasn't you, reset your password and review your sessions."
+	if err := SendMail(models.StringValue(user.Email), "A passkey was removed from your account", body); err != nil {
+		log.Println("error sending passkey notification email:", err)
+	}
+	return nil
+}
+
+// DeleteAllWebAuthnCredentials removes every passkey of the user, along with registrations in progress,
+// e.g. when the password is reset, since a passkey may have been added by whoever had the account.
+func DeleteAllWebAuthnCredentials(userID string) error {
+	// Create a context with a 100-second timeout for the database operations.
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if _, err := webauthnCeremonyCollection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	result, err := webauthnCredentialCollection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount > 0 {
+		LogSecurityEvent("webauthn_credentials_removed", map[string]interface{}{
+			"user_id":     userID,
+			"credentials": result.DeletedCount,
+		})
+	}
+	return nil
+}
+
+// ensureWebAuthnIndexes makes credential IDs unique and lets MongoDB drop expired ceremonies.
+func ensureWebAuthnIndexes(ctx context.Context) error {
+	_, err := webauthnCredentialCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "credential_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = webauthnCeremonyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ceremony_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -0,0 +1,233 @@
+//go:build integration
+
+// The WebAuthn ceremonies end to end against MongoDB, with a software authenticator in place of a
+// browser. Run with `go test -tags integration ./helpers/` and the database configured in .env.
+
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/rkmangalp/golang-JWT-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// softAuthenticator is a WebAuthn authenticator in software: one ES256 credential with "none"
+// attestation, producing the JSON encoded PublicKeyCredential a browser would send.
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		rpID:         relyingParty.Config.RPID,
+		origin:       relyingParty.Config.RPOrigins[0],
+		key:          key,
+		credentialID: credentialID,
+	}
+}
+
+// authenticatorData builds the authenticator data: RP ID hash, flags, signature counter and
+// the attested credential data, if any.
+func (a *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremonyType string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create() for the challenge.
+func (a *softAuthenticator) create(t *testing.T, challenge []byte) []byte {
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // All-zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+
+	// User present, user verified, attested credential data included.
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(0x45, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData(t, "webauthn.create", challenge)),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+	})
+}
+
+// get answers navigator.credentials.get() for the challenge, advancing the signature counter.
+func (a *softAuthenticator) get(t *testing.T, challenge []byte, userHandle string) []byte {
+	a.signCount++
+	authData := a.authenticatorData(0x05, nil) // User present, user verified
+	clientData := a.clientData(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		"userHandle":        base64.RawURLEncoding.EncodeToString([]byte(userHandle)),
+	})
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) []byte {
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	data, err := json.Marshal(map[string]interface{}{"id": id, "rawId": id, "type": "public-key", "response": response})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// insertTestUser stores a throwaway user and removes it, with its passkeys, when the test ends.
+func insertTestUser(t *testing.T) string {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	userID := primitive.NewObjectID().Hex()
+	email := "webauthn-" + userID + "@example.com"
+	firstName, lastName, userType := "Web", "Authn", "USER"
+	now := time.Now()
+	_, err := userCollection.InsertOne(ctx, models.User{
+		ID:         primitive.NewObjectID(),
+		First_name: &firstName,
+		Last_name:  &lastName,
+		Email:      &email,
+		User_type:  &userType,
+		User_id:    userID,
+		Created_at: now,
+		Updated_at: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		userCollection.DeleteOne(ctx, bson.M{"user_id": userID})
+		DeleteAllWebAuthnCredentials(userID)
+	})
+	return userID
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	userID := insertTestUser(t)
+	authenticator := newSoftAuthenticator(t)
+
+	creation, ceremonyID, err := BeginWebAuthnRegistration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := FinishWebAuthnRegistration(userID, ceremonyID, "Test key", authenticator.create(t, creation.Response.Challenge))
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	if credential.Credential_id != base64.RawURLEncoding.EncodeToString(authenticator.credentialID) || credential.Name != "Test key" {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+
+	// The same ceremony can't be finished twice.
+	if _, err := FinishWebAuthnRegistration(userID, ceremonyID, "", authenticator.create(t, creation.Response.Challenge)); err != ErrInvalidWebAuthnCeremony {
+		t.Fatalf("reused registration ceremony: got %v", err)
+	}
+
+	// Second factor for the user.
+	assertion, ceremonyID, err := BeginWebAuthnLogin(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loggedIn, err := FinishWebAuthnLogin(userID, ceremonyID, authenticator.get(t, assertion.Response.Challenge, userID))
+	if err != nil || loggedIn != userID {
+		t.Fatalf("MFA login: got %q, %v", loggedIn, err)
+	}
+
+	// Passwordless login identifies the user through the user handle.
+	assertion, ceremonyID, err = BeginWebAuthnLogin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loggedIn, err = FinishWebAuthnLogin("", ceremonyID, authenticator.get(t, assertion.Response.Challenge, userID))
+	if err != nil || loggedIn != userID {
+		t.Fatalf("passwordless login: got %q, %v", loggedIn, err)
+	}
+
+	// An answer to another challenge doesn't verify.
+	_, ceremonyID, err = BeginWebAuthnLogin(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FinishWebAuthnLogin(userID, ceremonyID, authenticator.get(t, []byte("another challenge"), userID)); err != ErrWebAuthnVerificationFailed {
+		t.Fatalf("wrong challenge: got %v", err)
+	}
+
+	// A signature counter that went backwards suggests a cloned authenticator.
+	assertion, ceremonyID, err = BeginWebAuthnLogin(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.signCount = 0
+	if _, err := FinishWebAuthnLogin(userID, ceremonyID, authenticator.get(t, assertion.Response.Challenge, userID)); err != ErrWebAuthnVerificationFailed {
+		t.Fatalf("cloned authenticator: got %v", err)
+	}
+
+	// A password reset removes every passkey.
+	if err := DeleteAllWebAuthnCredentials(userID); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := HasWebAuthnCredentials(userID); err != nil || has {
+		t.Fatalf("passkeys left after removing all: %v, %v", has, err)
+	}
+}
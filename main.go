@@ -1,9 +1,13 @@
 package main
 
 import (
+	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+	"github.com/rkmangalp/golang-JWT-project/middleware"
 	"github.com/rkmangalp/golang-JWT-project/routes"
 )
 
@@ -16,26 +20,52 @@ func main() {
 		port = "9000"
 	}
 
+	// Create the MongoDB indexes (unique keys, TTL expiry) the helpers rely on.
+	if err := helpers.EnsureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Migrate documents written by older versions of the service.
+	if err := helpers.RunMigrations(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Create a new Gin router instance.
 	router := gin.New()
 
+	// Only believe the forwarded client IP from the configured reverse proxies, so clients
+	// can't pick the IP they are rate limited and throttled by.
+	if err := router.SetTrustedProxies(helpers.TrustedProxies()); err != nil {
+		log.Fatal(err)
+	}
+
 	// Use the default logger middleware provided by Gin to log requests.
 	router.Use(gin.Logger())
 
+	// Require a CSRF token on state-changing requests authenticated with cookies.
+	// It must be registered before any route to apply to all of them.
+	router.Use(middleware.CSRFProtect())
+
 	// Register authentication routes from the routes package.
 	routes.AuthRoutes(router)
 
+	// Register the public key routes before the authenticated ones.
+	routes.KeyRoutes(router)
+
+	// Register the OAuth routes, which authenticate clients instead of users.
+	routes.OAuthRoutes(router)
+
 	// Register user-related routes from the routes package.
 	routes.UserRoutes(router)
 
-	// Define a GET endpoint "/api-1".
-	router.GET("/api-1", func(c *gin.Context) {
+	// Define a GET endpoint "/api-1", rate limited per user.
+	router.GET("/api-1", middleware.RateLimit("api_1", helpers.RateLimitRule{Limit: 100, Window: time.Minute}, middleware.KeyByUID), middleware.RequireVerifiedEmail(), func(c *gin.Context) {
 		// Respond with a JSON object indicating success.
 		c.JSON(200, gin.H{"success": "Access granted for api-1"})
 	})
 
-	// Define a GET endpoint "/api-2".
-	router.GET("/api-2", func(c *gin.Context) {
+	// Define a GET endpoint "/api-2", rate limited per user.
+	router.GET("/api-2", middleware.RateLimit("api_2", helpers.RateLimitRule{Limit: 100, Window: time.Minute}, middleware.KeyByUID), middleware.RequireVerifiedEmail(), func(c *gin.Context) {
 		// Respond with a JSON object indicating success.
 		c.JSON(200, gin.H{"success": "Access granted for api-2"})
 	})
@@ -1,32 +1,112 @@
 package middleware
 
 import (
-	"fmt"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rkmangalp/golang-JWT-project/helpers"
 )
 
+// Ways an access token can be presented, recorded in the context as "auth_transport".
+const (
+	TransportBearer = "bearer" // Authorization: Bearer header (RFC 6750)
+	TransportHeader = "header" // Legacy `token` header
+	TransportCookie = "cookie" // Access token cookie, when cookie mode is on
+)
+
+// accessTokenFromRequest returns the presented access token and how it was sent. The Authorization
+// header is preferred, then the legacy `token` header, then the cookie. ok is false when the
+// Authorization header uses the Bearer scheme but is malformed.
+func accessTokenFromRequest(c *gin.Context) (token, transport string, ok bool) {
+	if authorization := c.GetHeader("Authorization"); authorization != "" {
+		scheme, credentials, _ := strings.Cut(authorization, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			credentials = strings.TrimSpace(credentials)
+			if credentials == "" || strings.Contains(credentials, " ") {
+				return "", "", false
+			}
+			return credentials, TransportBearer, true
+		}
+	}
+	if token := c.GetHeader("token"); token != "" {
+		return token, TransportHeader, true
+	}
+	if helpers.AuthCookiesEnabled() {
+		if token, err := c.Cookie(helpers.AccessTokenCookie); err == nil && token != "" {
+			return token, TransportCookie, true
+		}
+	}
+	return "", "", true
+}
+
+// rejectToken aborts the request with an RFC 6750 error response.
+func rejectToken(c *gin.Context, status int, errorCode, description string) {
+	c.Header("WWW-Authenticate", helpers.BearerChallenge(errorCode, description))
+	c.JSON(status, gin.H{"error": description})
+	c.Abort()
+}
+
+// Authenticate requires a valid access token, presented as an `Authorization: Bearer` header,
+// the legacy `token` header or, in cookie mode, the access token cookie.
 func Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientToken := c.Request.Header.Get("token")
+		clientToken, transport, ok := accessTokenFromRequest(c)
+		if !ok {
+			rejectToken(c, http.StatusBadRequest, "invalid_request", "malformed Authorization header")
+			return
+		}
 		if clientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("-----------")})
-			c.Abort()
+			// No credentials at all: the challenge carries no error code (RFC 6750 §3.1).
+			rejectToken(c, http.StatusUnauthorized, "", "access token is required")
 			return
 		}
-		claims, err := helpers.ValidateToken(clientToken)
+		claims, err := helpers.ValidateAccessToken(clientToken)
 		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			rejectToken(c, http.StatusUnauthorized, "invalid_token", err)
+			return
+		}
+		revoked, revokedErr := helpers.IsAccessTokenRevoked(claims)
+		if revokedErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the token"})
 			c.Abort()
 			return
 		}
+		if revoked {
+			rejectToken(c, http.StatusUnauthorized, "invalid_token", "token has been revoked")
+			return
+		}
+		if claims.Session_id != "" {
+			// Record the session's last-seen time; failing to do so must not block the request.
+			if touchErr := helpers.TouchSession(claims.Session_id); touchErr != nil {
+				log.Println("error updating session last seen:", touchErr)
+			}
+		}
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid", claims.Uid)
 		c.Set("user_type", claims.User_type)
+		c.Set("email_verified", claims.Email_verified)
+		c.Set("mfa_enabled", claims.Mfa_enabled)
+		c.Set("session_id", claims.Session_id)
+		c.Set("jti", claims.Id)
+		c.Set("expires_at", claims.ExpiresAt)
+		c.Set("auth_transport", transport)
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail blocks users whose email address isn't verified when the email verification
+// policy is "restrict". It must run after Authenticate.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if helpers.EmailVerificationPolicy() == helpers.EmailVerificationRestrict && !c.GetBool("email_verified") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "email address is not verified"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+)
+
+// cookieAuthenticated reports whether the browser authenticates the request with the token
+// cookies: cookie mode is on, no token header was sent, and an access or refresh token cookie was.
+func cookieAuthenticated(c *gin.Context) bool {
+	if !helpers.AuthCookiesEnabled() {
+		return false
+	}
+	_, transport, ok := accessTokenFromRequest(c)
+	if !ok || transport == TransportBearer || transport == TransportHeader {
+		return false
+	}
+	if transport == TransportCookie {
+		return true
+	}
+	refreshToken, err := c.Cookie(helpers.RefreshTokenCookie)
+	return err == nil && refreshToken != ""
+}
+
+// CSRFProtect requires a matching double-submit CSRF token on every state-changing request the
+// browser authenticates with cookies, since the browser would send those cookies along with a
+// forged cross-site request too. Requests with a token header, and safe methods, are let through.
+// Clients fetch the token from GET /user/csrf-token and send it in the X-CSRF-Token header.
+func CSRFProtect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if cookieAuthenticated(c) && !helpers.ValidCSRFToken(c) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "CSRF token is missing or does not match",
+				"code":  "csrf_token_invalid",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+)
+
+// RateLimitKeyFunc picks what requests are counted together, e.g. per client IP or per user.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// KeyByIP counts requests per client IP.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUID counts requests per authenticated user, falling back to the client IP before Authenticate has run.
+func KeyByUID(c *gin.Context) string {
+	if uid := c.GetString("uid"); uid != "" {
+		return "uid:" + uid
+	}
+	return KeyByIP(c)
+}
+
+// KeyByRoute counts all requests to the route together, whoever makes them.
+func KeyByRoute(c *gin.Context) string {
+	return "route"
+}
+
+// rateLimitStore is shared by every rate limited route; RATE_LIMIT_STORE chooses the backend.
+var rateLimitStore = helpers.NewRateLimitStore()
+
+// RateLimit limits the requests to a route. name identifies the limit: requests are counted per
+// name and key, and the default rule can be overridden with RATE_LIMIT_<NAME>, e.g. "100/1m".
+// Every response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers;
+// requests over the limit get 429 with Retry-After. If the store fails the request is let through.
+func RateLimit(name string, defaultRule helpers.RateLimitRule, keyFunc RateLimitKeyFunc) gin.HandlerFunc {
+	rule := helpers.RateLimitRuleFromEnv(name, defaultRule)
+	policy := strconv.Itoa(rule.Limit) + ";w=" + strconv.Itoa(int(rule.Window/time.Second))
+
+	return func(c *gin.Context) {
+		result, err := rateLimitStore.Hit(name+":"+keyFunc(c), rule.Limit, rule.Window)
+		if err != nil {
+			log.Println("error checking rate limit:", err)
+			c.Next()
+			return
+		}
+
+		reset := strconv.Itoa(int(math.Ceil(result.Reset.Seconds())))
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", reset)
+
+		if !result.Allowed {
+			c.Header("Retry-After", reset)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded, try again later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
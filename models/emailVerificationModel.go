@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailVerification is a pending email address verification. Only the fingerprint of the emailed
+// token is stored, together with the address it verifies; the document is deleted when the token
+// is used and expired ones are removed by a TTL index.
+type EmailVerification struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Token_hash string             `json:"-"`
+	User_id    string             `json:"user_id"`
+	Email      string             `json:"email"` // Address the link was sent to
+	Expires_at time.Time          `json:"expires_at"`
+	Created_at time.Time          `json:"created_at"`
+}
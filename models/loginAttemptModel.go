@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginAttempt counts the recent failed logins for one account or one client IP, keyed by
+// "account:<email>" or "ip:<address>", and the wrong codes entered with one MFA pending token,
+// keyed by "mfa:<token ID>". Login attempts are counted as they start, so Failures also
+// includes attempts still in progress. The counter is forgotten once Expires_at passes without
+// a new failure, and Locked_until is set while the key is temporarily locked out.
+type LoginAttempt struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Key             string             `json:"key"`
+	Failures        int                `json:"failures"`
+	Last_failure_at time.Time          `json:"last_failure_at"`
+	Locked_until    *time.Time         `json:"locked_until,omitempty"`
+	Expires_at      time.Time          `json:"expires_at"`
+}
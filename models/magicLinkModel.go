@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MagicLink is a pending passwordless sign-in. Only fingerprints of the emailed token and of the
+// nonce cookie set in the requesting browser are stored; the document is deleted on first use
+// and expired ones are removed by a TTL index.
+type MagicLink struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Token_hash string             `json:"-"`
+	Nonce_hash string             `json:"-"`
+	User_id    string             `json:"user_id"`
+	Email      string             `json:"email"` // Address the link was sent to
+	Expires_at time.Time          `json:"expires_at"`
+	Created_at time.Time          `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset is a pending password reset. Only the fingerprint of the emailed token is stored;
+// the document is deleted when the token is used and expired ones are removed by a TTL index.
+type PasswordReset struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Token_hash string             `json:"-"`
+	User_id    string             `json:"user_id"`
+	Expires_at time.Time          `json:"expires_at"`
+	Created_at time.Time          `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevokedToken is a denylist entry for a token ID (jti). Entries are removed by a TTL index
+// once Expires_at has passed, since the token would be rejected as expired anyway.
+type RevokedToken struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Jti        string             `json:"jti"`
+	User_id    string             `json:"user_id"`
+	Expires_at time.Time          `json:"expires_at"`
+	Revoked_at time.Time          `json:"revoked_at"`
+}
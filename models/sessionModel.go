@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a single login on one device, keyed by Session_id, and tracks the family of
+// refresh tokens issued from it.
+// Every rotation moves the current token ID into Used_refresh_token_ids, so a replayed
+// older token can be told apart from the one the legitimate client holds. Only a fingerprint
+// of the current refresh token is stored, never the token itself. The ID and expiry of
+// the access token issued alongside the current refresh token are kept so it can be revoked too.
+// Refresh_expires_at is when the current refresh token expires; past it the session is dead
+// and MongoDB removes it.
+type Session struct {
+	ID                     primitive.ObjectID `bson:"_id"`
+	Session_id             string             `json:"session_id"`
+	User_id                string             `json:"user_id"`
+	Device_name            string             `json:"device_name"`
+	User_agent             string             `json:"user_agent"`
+	Ip_address             string             `json:"ip_address"`
+	Refresh_token_id       string             `json:"-"`
+	Refresh_token_hash     string             `json:"-"`
+	Used_refresh_token_ids []string           `json:"-"`
+	Access_token_id        string             `json:"-"`
+	Access_expires_at      time.Time          `json:"-"`
+	Refresh_expires_at     time.Time          `json:"expires_at"`
+	Revoked                bool               `json:"revoked"`
+	Revoked_at             *time.Time         `json:"revoked_at,omitempty"`
+	Created_at             time.Time          `json:"created_at"`
+	Last_seen_at           time.Time          `json:"last_seen_at"`
+	Updated_at             time.Time          `json:"updated_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SigningKey is one entry of the token signing key ring.
+// Exactly one key is ACTIVE and signs new tokens; VERIFY_ONLY keys are still accepted
+// (until Verify_until, when set) and RETIRED keys are no longer trusted at all.
+// Private_key is the key material sealed with the key encryption key, never plaintext.
+type SigningKey struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Kid          string             `json:"kid"`
+	Algorithm    string             `json:"algorithm" validate:"required"`
+	Private_key  string             `json:"-"`
+	Status       string             `json:"status" validate:"eq=ACTIVE|eq=VERIFY_ONLY|eq=RETIRED"`
+	Verify_until *time.Time         `json:"verify_until"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+}
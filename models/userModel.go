@@ -7,16 +7,23 @@ import (
 )
 
 type User struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	First_name    *string            `json:"first_name" validate:"required,min=2,max=100"`
-	Last_name     *string            `json:"last_name" validate:"required,min=2,max=100"`
-	Password      *string            `json:"password" validate:"required,min=6"`
-	Email         *string            `json:"email" validate:"email,required"`
-	Phone         *string            `json:"phone" validate:"required"`
-	Token         *string            `json:"token"`
-	User_type     *string            `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
-	Refresh_token *string            `json:"refresh_token"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	User_id       string             `json:"user_id"`
+	ID             primitive.ObjectID `bson:"_id"`
+	First_name     *string            `json:"first_name" validate:"required,min=2,max=100"`
+	Last_name      *string            `json:"last_name" validate:"required,min=2,max=100"`
+	Password       *string            `json:"password" validate:"required"`
+	Email          *string            `json:"email" validate:"email,required"`
+	Email_verified bool               `json:"email_verified"`
+	Phone          *string            `json:"phone" validate:"required"`
+	User_type      *string            `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+	User_id        string             `json:"user_id"`
+	// Hashes of the previous passwords, newest first, so they can't be reused.
+	Password_history []string `json:"-"`
+	// Two-factor authentication state. None of it can be set through the request body.
+	Mfa_enabled         bool     `json:"-"`
+	Totp_secret         *string  `json:"-"` // Sealed with the key encryption key
+	Totp_pending_secret *string  `json:"-"` // Sealed with the key encryption key
+	Totp_last_step      int64    `json:"-"`
+	Recovery_codes      []string `json:"-"` // Hashes of the unused recovery codes
 }
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// UserResponse is how a user is returned to the user themselves. It is built field by field from
+// User, so stored secrets such as the password hash can never end up in a response.
+type UserResponse struct {
+	User_id        string `json:"user_id"`
+	First_name     string `json:"first_name"`
+	Last_name      string `json:"last_name"`
+	Email          string `json:"email"`
+	Email_verified bool   `json:"email_verified"`
+	Phone          string `json:"phone"`
+	User_type      string `json:"user_type"`
+}
+
+// AdminUserResponse is how a user is returned to an ADMIN: the public fields plus account metadata.
+type AdminUserResponse struct {
+	UserResponse
+	Created_at time.Time `json:"created_at"`
+	Updated_at time.Time `json:"updated_at"`
+}
+
+// LoginResponse is the user returned by a login, with the token pair minted for this login.
+// Tokens are never read back from the database. In cookie mode the tokens are only set as
+// HttpOnly cookies and left out of the body, where scripts could read them.
+type LoginResponse struct {
+	UserResponse
+	Token         string `json:"token,omitempty"`
+	Refresh_token string `json:"refresh_token,omitempty"`
+}
+
+// NewUserResponse builds the public view of a user.
+func NewUserResponse(user User) UserResponse {
+	return UserResponse{
+		User_id:        user.User_id,
+		First_name:     StringValue(user.First_name),
+		Last_name:      StringValue(user.Last_name),
+		Email:          StringValue(user.Email),
+		Email_verified: user.Email_verified,
+		Phone:          StringValue(user.Phone),
+		User_type:      StringValue(user.User_type),
+	}
+}
+
+// NewAdminUserResponse builds the admin view of a user.
+func NewAdminUserResponse(user User) AdminUserResponse {
+	return AdminUserResponse{
+		UserResponse: NewUserResponse(user),
+		Created_at:   user.Created_at,
+		Updated_at:   user.Updated_at,
+	}
+}
+
+// UserResponseFor picks the view of a user that a viewer of the given user type may see.
+func UserResponseFor(user User, viewerType string) interface{} {
+	if viewerType == "ADMIN" {
+		return NewAdminUserResponse(user)
+	}
+	return NewUserResponse(user)
+}
+
+// StringValue dereferences an optional string field, such as those of User, to "" when it is unset.
+func StringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user. Credential_id is the
+// base64url encoded credential ID chosen by the authenticator; Sign_count is the last signature
+// counter seen, used to detect cloned authenticators.
+type WebAuthnCredential struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Credential_id    string             `json:"credential_id"`
+	User_id          string             `json:"user_id"`
+	Name             string             `json:"name"`
+	Public_key       []byte             `json:"-"`
+	Attestation_type string             `json:"-"`
+	Transports       []string           `json:"transports"`
+	Aaguid           []byte             `json:"-"`
+	Sign_count       uint32             `json:"-"`
+	User_verified    bool               `json:"-"`
+	Backup_eligible  bool               `json:"backup_eligible"`
+	Backup_state     bool               `json:"backup_state"`
+	Created_at       time.Time          `json:"created_at"`
+	Last_used_at     *time.Time         `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnCeremony is the server side state of a registration or login ceremony in progress,
+// referenced by the opaque Ceremony_id handed to the client. It is deleted when the ceremony is
+// finished and expired ones are removed by a TTL index.
+type WebAuthnCeremony struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Ceremony_id  string             `json:"ceremony_id"`
+	Purpose      string             `json:"purpose"`
+	User_id      string             `json:"user_id"` // Empty for a discoverable (passwordless) login
+	Session_data string             `json:"-"`       // JSON encoded webauthn.SessionData
+	Expires_at   time.Time          `json:"expires_at"`
+}
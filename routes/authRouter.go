@@ -1,17 +1,72 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	controllers "github.com/rkmangalp/golang-JWT-project/controllers"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
+	"github.com/rkmangalp/golang-JWT-project/middleware"
 )
 
 // AuthRoutes defines the routes for user authentication.
 func AuthRoutes(incomingRoutes *gin.Engine) {
     // Route for user signup:
     // When a POST request is made to "user/signup", the Signup controller handles it.
-    incomingRoutes.POST("user/signup", controllers.Signup())
+    // Signups are rate limited per client IP.
+    incomingRoutes.POST("user/signup", middleware.RateLimit("signup", helpers.RateLimitRule{Limit: 10, Window: time.Hour}, middleware.KeyByIP), controllers.Signup())
     
     // Route for user login:
     // When a POST request is made to "user/login", the Login controller handles it.
-    incomingRoutes.POST("user/login", controllers.Login())
+    // Every attempt costs a password hash, so logins are rate limited per client IP on top of the
+    // per-account and per-IP backoff on failures.
+    incomingRoutes.POST("user/login", middleware.RateLimit("login", helpers.RateLimitRule{Limit: 30, Window: time.Minute}, middleware.KeyByIP), controllers.Login())
+
+    // Route for the second step of a two-factor login:
+    // When MFA is enabled, "user/login" returns an MFA pending token, which a POST request to
+    // "user/login/mfa" exchanges for the token pair together with a TOTP or recovery code.
+    incomingRoutes.POST("user/login/mfa", controllers.VerifyMFALogin())
+
+    // Routes for a passkey as the second factor:
+    // With the MFA pending token, "user/login/mfa/webauthn/begin" returns a challenge for the user's
+    // passkeys and "user/login/mfa/webauthn/finish" exchanges the signed answer for the token pair.
+    incomingRoutes.POST("user/login/mfa/webauthn/begin", controllers.BeginWebAuthnMFA())
+    incomingRoutes.POST("user/login/mfa/webauthn/finish", controllers.FinishWebAuthnMFA())
+
+    // Routes for passwordless login with a passkey:
+    // "user/login/webauthn/begin" returns a challenge any discoverable passkey can answer, and
+    // "user/login/webauthn/finish" checks the answer and returns the token pair like "user/login".
+    incomingRoutes.POST("user/login/webauthn/begin", controllers.BeginWebAuthnLogin())
+    incomingRoutes.POST("user/login/webauthn/finish", controllers.FinishWebAuthnLogin())
+
+    // Routes for passwordless login by email:
+    // A POST request to "user/login/magic-link" emails a single-use sign-in link and binds it to the
+    // requesting browser with a nonce cookie; a GET request to "user/login/magic-link/callback" from
+    // that browser redeems the link like "user/login". Requests for links are rate limited per client IP.
+    incomingRoutes.POST("user/login/magic-link", middleware.RateLimit("magic_link", helpers.RateLimitRule{Limit: 10, Window: time.Hour}, middleware.KeyByIP), controllers.RequestMagicLink())
+    incomingRoutes.GET("user/login/magic-link/callback", controllers.MagicLinkCallback())
+
+    // Route for fetching a CSRF token:
+    // Browser apps in cookie mode send the token from a GET request to "user/csrf-token" in the
+    // X-CSRF-Token header of every state-changing request.
+    incomingRoutes.GET("user/csrf-token", controllers.GetCSRFToken())
+
+    // Route for refreshing tokens:
+    // When a POST request is made to "user/refresh", the Refresh controller exchanges
+    // the presented refresh token for a new access/refresh token pair.
+    incomingRoutes.POST("user/refresh", controllers.Refresh())
+
+    // Routes for email verification:
+    // A GET request to "user/verify-email" consumes the link sent at signup, and a POST request
+    // to "user/verify-email/resend" sends a new one. Resends are rate limited per client IP.
+    incomingRoutes.GET("user/verify-email", controllers.VerifyEmail())
+    incomingRoutes.POST("user/verify-email/resend", middleware.RateLimit("verify_email_resend", helpers.RateLimitRule{Limit: 10, Window: time.Hour}, middleware.KeyByIP), controllers.ResendVerificationEmail())
+
+    // Routes for account recovery:
+    // A POST request to "user/password/forgot" emails a reset link, a GET request to
+    // "user/password/reset" checks the link's token without redeeming it, and a POST request
+    // to "user/password/reset" redeems it and sets the new password. Reset emails are rate limited per client IP.
+    incomingRoutes.POST("user/password/forgot", middleware.RateLimit("password_forgot", helpers.RateLimitRule{Limit: 10, Window: time.Hour}, middleware.KeyByIP), controllers.ForgotPassword())
+    incomingRoutes.GET("user/password/reset", controllers.CheckPasswordReset())
+    incomingRoutes.POST("user/password/reset", controllers.ResetPassword())
 }
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/controllers"
+)
+
+// KeyRoutes defines the public routes other services use to verify our tokens.
+func KeyRoutes(incomingRoutes *gin.Engine) {
+	// Route for the JSON Web Key Set:
+	// When a GET request is made to "/.well-known/jwks.json", the JWKS controller
+	// returns the public keys tokens are currently signed with.
+	incomingRoutes.GET("/.well-known/jwks.json", controllers.JWKS())
+}
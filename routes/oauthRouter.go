@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rkmangalp/golang-JWT-project/controllers"
+)
+
+// OAuthRoutes defines the OAuth 2.0 endpoints used by API gateways and OAuth client libraries.
+// They authenticate the calling client themselves, so they are registered without user authentication.
+func OAuthRoutes(incomingRoutes *gin.Engine) {
+	// Route for token introspection (RFC 7662):
+	// When a POST request is made to "/oauth/introspect", the Introspect controller reports
+	// whether the token is active and describes it.
+	incomingRoutes.POST("/oauth/introspect", controllers.Introspect())
+
+	// Route for token revocation (RFC 7009):
+	// When a POST request is made to "/oauth/revoke", the Revoke controller revokes the
+	// access or refresh token; revoking a refresh token also revokes the access tokens derived from it.
+	incomingRoutes.POST("/oauth/revoke", controllers.Revoke())
+}
@@ -1,8 +1,11 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/rkmangalp/golang-JWT-project/controllers"
+	"github.com/rkmangalp/golang-JWT-project/helpers"
 	"github.com/rkmangalp/golang-JWT-project/middleware"
 )
 
@@ -12,12 +15,55 @@ func UserRoutes(incomingRoutes *gin.Engine) {
 	// Apply the authentication middleware to all routes defined in this function.
 	incomingRoutes.Use(middleware.Authenticate())
 
+	// Routes that expose user data also require a verified email address when the
+	// email verification policy is "restrict".
+
 	// Define a route for getting a list of users.
 	// The GET request to "/users" will be handled by the GetUsers controller function.
-	incomingRoutes.GET("/users", controllers.GetUsers())
+	// It is rate limited per user.
+	incomingRoutes.GET("/users", middleware.RateLimit("users", helpers.RateLimitRule{Limit: 60, Window: time.Minute}, middleware.KeyByUID), middleware.RequireVerifiedEmail(), controllers.GetUsers())
 
 	// Define a route for getting a specific user by user ID.
 	// The GET request to "/user/:user_id" will be handled by the GetUser controller function.
 	// ":user_id" is a path parameter that will be passed to the controller function.
-	incomingRoutes.GET("/user/:user_id", controllers.GetUser())
+	incomingRoutes.GET("/user/:user_id", middleware.RequireVerifiedEmail(), controllers.GetUser())
+
+	// Define a route for logging out.
+	// The POST request to "/user/logout" revokes the caller's access token and ends their session.
+	incomingRoutes.POST("/user/logout", controllers.Logout())
+
+	// Define a route for changing the caller's password.
+	// The PUT request to "/user/password" also ends the caller's other sessions.
+	incomingRoutes.PUT("/user/password", controllers.ChangePassword())
+
+	// Define the routes for TOTP two-factor authentication.
+	// Enrollment returns a secret and QR code, which is confirmed with a first code; recovery codes
+	// can be regenerated and MFA disabled with the password and a second factor.
+	incomingRoutes.POST("/user/mfa/totp/enroll", controllers.EnrollTOTP())
+	incomingRoutes.POST("/user/mfa/totp/confirm", controllers.ConfirmTOTP())
+	incomingRoutes.POST("/user/mfa/totp/disable", controllers.DisableTOTP())
+	incomingRoutes.POST("/user/mfa/recovery-codes", controllers.RegenerateRecoveryCodes())
+
+	// Define the routes for managing the caller's passkeys (WebAuthn credentials).
+	// Registration is a begin/finish ceremony, begun with the current password or a two-factor code;
+	// registered passkeys can be listed and removed.
+	incomingRoutes.POST("/user/webauthn/register/begin", controllers.BeginWebAuthnRegistration())
+	incomingRoutes.POST("/user/webauthn/register/finish", controllers.FinishWebAuthnRegistration())
+	incomingRoutes.GET("/user/webauthn/credentials", controllers.GetWebAuthnCredentials())
+	incomingRoutes.DELETE("/user/webauthn/credentials/:id", controllers.DeleteWebAuthnCredential())
+
+	// Define the routes for managing the caller's own sessions (one per device login).
+	// The GET request to "/user/sessions" lists them and the DELETE request terminates one.
+	incomingRoutes.GET("/user/sessions", controllers.GetSessions())
+	incomingRoutes.DELETE("/user/sessions/:id", controllers.DeleteSession())
+
+	// Define an admin route for revoking every session of a user.
+	incomingRoutes.POST("/users/:user_id/revoke-sessions", controllers.RevokeUserSessions())
+
+	// Define the admin routes for managing the signing key ring.
+	// New keys are added verify-only, promoted to active, and retired once no longer trusted.
+	incomingRoutes.GET("/keys", controllers.GetSigningKeys())
+	incomingRoutes.POST("/keys", controllers.AddSigningKey())
+	incomingRoutes.POST("/keys/:kid/promote", controllers.PromoteSigningKey())
+	incomingRoutes.POST("/keys/:kid/retire", controllers.RetireSigningKey())
 }